@@ -0,0 +1,251 @@
+package main
+
+import (
+	"cni-benchmark/pkg/config"
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+// newRootCommand builds the command tree. Without a subcommand the mode comes
+// from the configuration, so containers setting MODE keep working.
+func newRootCommand(start time.Time) *cobra.Command {
+	root := &cobra.Command{
+		Use:          "cni-benchmark-operator",
+		Short:        "Benchmark CNI plugins with iperf3 and DNS queries",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE:         runMode(start, nil),
+	}
+	persistent := root.PersistentFlags()
+	persistent.String(config.FileFlag, "", "YAML or JSON configuration file, defaults to CONFIG_FILE")
+	persistent.String("log-level", "", "log level like debug, info or error, or a verbosity like 2")
+	persistent.String("log-format", "", "log format: console or json")
+	persistent.String("test-case", "", "name of the test case")
+	// Only a file, the URL itself would show up in the process list
+	persistent.String("database-url-file", "", "file with the database connection URL")
+	persistent.Bool("standalone", false, "run without Kubernetes")
+	bindKeys(persistent, map[string]string{"log-level": "log.level", "log-format": "log.format"})
+	completeValues(root, "log-format", "console", "json")
+
+	root.Flags().String("mode", "", "mode to run in: client, server or dns")
+	completeValues(root, "mode", "client", "server", "dns")
+	addClientFlags(root.Flags())
+	addIPFamilyFlag(root)
+	addDryRunFlag(root.Flags())
+
+	root.AddCommand(
+		newServerCommand(start),
+		newClientCommand(start),
+		newDNSCommand(start),
+		newValidateCommand(),
+		newMigrateCommand(),
+		newReportCommand(),
+		newCompareCommand(),
+		newReplayCommand(),
+		newVersionCommand(),
+	)
+	return root
+}
+
+func newServerCommand(start time.Time) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "server",
+		Short: "Run supervised iperf3 servers",
+		Args:  cobra.NoArgs,
+		RunE:  runMode(start, map[string]any{"mode": config.ModeServer.String()}),
+	}
+	flags := cmd.Flags()
+	flags.Uint16("port", 0, "first port to listen on")
+	flags.Int("servers", 0, "number of iperf3 servers on consecutive ports")
+	flags.String("health-address", "", "address serving /healthz and /readyz")
+	bindKeys(flags, map[string]string{"servers": "supervisor.servers", "health-address": "supervisor.health_address"})
+	addIPFamilyFlag(cmd)
+	addDryRunFlag(flags)
+	return cmd
+}
+
+func newClientCommand(start time.Time) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "client",
+		Short: "Benchmark iperf3 servers and store the results",
+		Args:  cobra.NoArgs,
+		RunE:  runMode(start, map[string]any{"mode": config.ModeClient.String()}),
+	}
+	addClientFlags(cmd.Flags())
+	addIPFamilyFlag(cmd)
+	flags := cmd.Flags()
+	flags.String("targets-service", "", "server Service as namespace/name to resolve targets from")
+	flags.Bool("force-rerun", false, "run even when the test case is marked as completed")
+	flags.String("lease-lock", "", "leader election lock: leases or none")
+	flags.String("metrics-address", "", "address serving Prometheus metrics")
+	bindKeys(flags, map[string]string{
+		"targets-service": "targets.service",
+		"lease-lock":      "lease.lock",
+		"metrics-address": "metrics.address",
+	})
+	completeValues(cmd, "lease-lock", config.LockLeases, config.LockNone)
+	addDryRunFlag(flags)
+	return cmd
+}
+
+func newDNSCommand(start time.Time) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "dns",
+		Short: "Benchmark DNS resolution and store the results",
+		Args:  cobra.NoArgs,
+		RunE:  runMode(start, map[string]any{"mode": config.ModeDNS.String()}),
+	}
+	flags := cmd.Flags()
+	flags.StringSlice("dns-names", nil, "names to resolve on every round")
+	flags.String("dns-server", "", "resolver address, the system one when empty")
+	flags.Uint16("duration", 0, "test duration in seconds")
+	bindKeys(flags, map[string]string{"dns-names": "dns.names", "dns-server": "dns.server"})
+	addDryRunFlag(flags)
+	return cmd
+}
+
+func newValidateCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Check the configuration and report all errors at once",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if _, err := load(cmd, nil); err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), "configuration is valid")
+			return nil
+		},
+	}
+	cmd.Flags().String("mode", "", "mode to validate the configuration for")
+	completeValues(cmd, "mode", "client", "server", "dns")
+	addClientFlags(cmd.Flags())
+	addIPFamilyFlag(cmd)
+	return cmd
+}
+
+func newVersionCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "version",
+		Short: "Print the version",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, _ []string) {
+			revision := "unknown"
+			goVersion := ""
+			if info, ok := debug.ReadBuildInfo(); ok {
+				goVersion = info.GoVersion
+				for _, setting := range info.Settings {
+					if setting.Key == "vcs.revision" {
+						revision = setting.Value
+					}
+				}
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "cni-benchmark-operator %s (revision %s, %s)\n", version, revision, goVersion)
+		},
+	}
+}
+
+// addClientFlags adds flags of the iperf3 client connection
+func addClientFlags(flags *pflag.FlagSet) {
+	flags.String("server", "", "iperf3 server address or reference")
+	flags.Uint16("port", 0, "port to connect to")
+	flags.Uint16("duration", 0, "test duration in seconds")
+}
+
+// addIPFamilyFlag adds the flag restricting iperf3 to an IP family
+func addIPFamilyFlag(cmd *cobra.Command) {
+	cmd.Flags().String("ip-family", "", "IP family: ipv4, ipv6 or dual to benchmark both")
+	completeValues(cmd, "ip-family", string(config.IPFamilyIPv4), string(config.IPFamilyIPv6), string(config.IPFamilyDual))
+}
+
+func addDryRunFlag(flags *pflag.FlagSet) {
+	flags.Bool("dry-run", false, "print the resolved configuration and iperf3 commands without running anything")
+	bindKeys(flags, map[string]string{"dry-run": ""})
+}
+
+// bindKeys binds flags to configuration keys their names don't match, an
+// empty key keeps the flag out of the configuration
+func bindKeys(flags *pflag.FlagSet, keys map[string]string) {
+	for name, key := range keys {
+		cobra.CheckErr(flags.SetAnnotation(name, config.KeyAnnotation, []string{key}))
+	}
+}
+
+// completeValues completes the flag with fixed values
+func completeValues(cmd *cobra.Command, name string, values ...string) {
+	cobra.CheckErr(cmd.RegisterFlagCompletionFunc(name, cobra.FixedCompletions(values, cobra.ShellCompDirectiveNoFileComp)))
+}
+
+// load builds the configuration from the file, environment and flags of the
+// command, overrides win over all of them
+func load(cmd *cobra.Command, overrides map[string]any) (*config.Config, error) {
+	file, err := cmd.Flags().GetString(config.FileFlag)
+	if err != nil {
+		return nil, err
+	}
+	return config.BuildFrom(config.Source{File: file, Flags: cmd.Flags(), Overrides: overrides})
+}
+
+// runMode runs the benchmark in the mode from the configuration or the
+// overrides, or prints what it would run with --dry-run
+func runMode(start time.Time, overrides map[string]any) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, _ []string) error {
+		cfg, err := load(cmd, overrides)
+		if err != nil {
+			return err
+		}
+		if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+			return printDryRun(cmd.OutOrStdout(), cfg)
+		}
+		run(cfg, start)
+		return nil
+	}
+}
+
+// printDryRun prints the resolved configuration and the iperf3 commands of
+// the mode with credentials masked
+func printDryRun(w io.Writer, cfg *config.Config) error {
+	dump, err := json.MarshalIndent(cfg.MarshalLog(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to print the configuration: %w", err)
+	}
+	fmt.Fprintf(w, "%s\n", dump)
+	switch cfg.Mode {
+	case config.ModeServer:
+		for i := range cfg.Supervisor.Servers {
+			fmt.Fprintln(w, shellJoin(cfg.WithPort(cfg.Port+uint16(i)).RedactedCommand()))
+		}
+	case config.ModeClient:
+		if len(cfg.Targets.Service) > 0 {
+			fmt.Fprintln(w, "# --client and --port are set per target resolved from", cfg.Targets.Service)
+		}
+		for _, family := range ipFamilies(cfg) {
+			fmt.Fprintln(w, shellJoin(cfg.WithIPFamily(family).RedactedCommand()))
+		}
+	case config.ModeDNS:
+		// DNS queries are sent by the process itself
+	}
+	return nil
+}
+
+// shellJoin joins the command quoting arguments for a POSIX shell
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if len(arg) == 0 || strings.ContainsAny(arg, " \t\n'\"\\$`*?;&|<>()") {
+			arg = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
@@ -2,103 +2,162 @@ package main
 
 import (
 	"cni-benchmark/pkg/config"
+	"cni-benchmark/pkg/dns"
 	"cni-benchmark/pkg/iperf3"
+	"cni-benchmark/pkg/logging"
+	"cni-benchmark/pkg/metrics"
+	"cni-benchmark/pkg/queue"
+	"cni-benchmark/pkg/server"
+	"cni-benchmark/pkg/status"
+	"cni-benchmark/pkg/target"
+	"cni-benchmark/pkg/tracing"
 	"context"
+	"errors"
+	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/go-logr/logr"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/leaderelection"
 	"k8s.io/client-go/tools/leaderelection/resourcelock"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 )
 
-var log logr.Logger
+// log delegates to the logger set once the configuration is built
+var log = logf.Log
 
-func init() {
-	logf.SetLogger(zap.New(zap.ConsoleEncoder(), zap.UseDevMode(true)))
-	log = logf.FromContext(context.Background())
-}
+// shutdownTracing flushes spans before the process exits
+var shutdownTracing = func(context.Context) error { return nil }
 
 func main() {
-	cfg, err := config.Build()
-	if err != nil {
-		log.Error(err, "failed to build a config")
+	if err := newRootCommand(time.Now()).Execute(); err != nil {
 		os.Exit(1)
 	}
+}
 
+// run sets up logging, tracing and metrics and runs the configured mode
+func run(cfg *config.Config, start time.Time) {
+	if err := setupLogging(cfg); err != nil {
+		log.Error(err, "failed to set up logging")
+		os.Exit(1)
+	}
 	log.Info("configuration object is built", "configuration", cfg)
 
+	var err error
+	if shutdownTracing, err = tracing.Setup(context.Background(), cfg); err != nil {
+		log.Error(err, "failed to set up tracing")
+		os.Exit(1)
+	}
+
+	if len(cfg.Metrics.Address) > 0 {
+		go func() {
+			if err := metrics.Serve(context.Background(), cfg.Metrics.Address); err != nil {
+				log.Error(err, "failed to serve metrics")
+			}
+		}()
+	}
+
 	switch cfg.Mode {
-	case config.ModeClient:
-		runClient(cfg)
+	case config.ModeClient, config.ModeDNS:
+		runClient(cfg, start)
 	case config.ModeServer:
 		runServer(cfg)
 	}
 }
 
+// setupLogging replaces the logger with the configured one, lines carry the
+// test case and lease ID
+func setupLogging(cfg *config.Config) error {
+	logger, err := logging.New(cfg.Log.Level, cfg.Log.Format)
+	if err != nil {
+		logf.SetLogger(zap.New(zap.ConsoleEncoder()))
+		return err
+	}
+	logf.SetLogger(logger.WithValues("test_case", cfg.TestCase, "lease_id", cfg.Lease.ID))
+	return nil
+}
+
 func runServer(cfg *config.Config) {
-	log.Info("starting in server mode")
-	if _, err := iperf3.Run(context.Background(), cfg); err != nil {
+	log.Info("starting in server mode", "servers", cfg.Supervisor.Servers)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := server.NewSupervisor(cfg).Run(ctx); err != nil {
 		log.Error(err, "server fatal error")
 		os.Exit(1)
 	}
 }
 
-func runClient(cfg *config.Config) {
-	log.Info("starting in client mode")
-	client, err := config.BuildKubernetesClient()
-	if err != nil {
-		log.Error(err, "failed to build kubernetes client")
-		os.Exit(1)
-	}
+func runClient(cfg *config.Config, start time.Time) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx, _ = tracing.Start(ctx, "client", trace.WithTimestamp(start))
+	_, span := tracing.Start(ctx, "config.build", trace.WithTimestamp(start))
+	span.End()
 
-	// Configure the leader election
-	lock := &resourcelock.LeaseLock{
-		LeaseMeta: metav1.ObjectMeta{
-			Name:      cfg.Lease.Name,
-			Namespace: cfg.Lease.Namespace,
-		},
-		Client: client.CoordinationV1(),
-		LockConfig: resourcelock.ResourceLockConfig{
-			Identity: cfg.Lease.ID,
-		},
+	log.Info("starting in client mode", "standalone", cfg.Standalone)
+	var client *kubernetes.Clientset
+	if !cfg.Standalone {
+		var err error
+		if client, err = config.BuildKubernetesClient(); err != nil {
+			log.Error(err, "failed to build kubernetes client")
+			exit(ctx, cfg, err)
+		}
+		cfg.K8sClient = client
 	}
 
 	info := &iperf3.Info{}
-	if err = info.Build(cfg); err != nil {
+	infoCtx, span := tracing.Start(ctx, "info.build")
+	err := info.Build(infoCtx, cfg)
+	span.SetAttributes(info.Attributes()...)
+	tracing.End(span, err)
+	if err != nil {
 		log.Error(err, "failed to gather information")
-		os.Exit(1)
+		exit(ctx, cfg, err)
 	}
 	log.Info("gathering system information", "info", info)
 
+	if cfg.Lease.Lock == config.LockNone {
+		log.Info("leader election is disabled, starting benchmark")
+		runBenchmark(ctx, cfg, info)
+		return
+	}
+
+	// Configure the leader election
+	lock, err := resourcelock.New(cfg.Lease.Lock, cfg.Lease.Namespace, cfg.Lease.Name,
+		client.CoreV1(), client.CoordinationV1(), resourcelock.ResourceLockConfig{Identity: cfg.Lease.ID})
+	if err != nil {
+		log.Error(err, "failed to create the leader election lock")
+		exit(ctx, cfg, err)
+	}
+
 	// Create leader election config
+	_, wait := tracing.Start(ctx, "leader_election.wait")
+	// Ended once leading starts, the election may also stop while waiting
+	defer wait.End()
 	leaderConfig := leaderelection.LeaderElectionConfig{
 		Lock:            lock,
 		ReleaseOnCancel: true,
-		LeaseDuration:   20 * time.Second,
-		RenewDeadline:   10 * time.Second,
-		RetryPeriod:     time.Second,
+		LeaseDuration:   cfg.Lease.Duration,
+		RenewDeadline:   cfg.Lease.RenewDeadline,
+		RetryPeriod:     cfg.Lease.RetryPeriod,
 		Callbacks: leaderelection.LeaderCallbacks{
 			OnStartedLeading: func(ctx context.Context) {
+				wait.End()
 				log.Info("got leadership, starting benchmark")
-				var report *iperf3.Report
-				if report, err = iperf3.Run(ctx, cfg); err != nil {
-					log.Error(err, "iperf3 run failed")
-					os.Exit(1)
-				}
-				log.Info("saving data")
-				if err = iperf3.Store(ctx, cfg, report, info); err != nil {
-					log.Error(err, "metrics upload failed")
-					os.Exit(1)
-				}
-				os.Exit(0)
+				runBenchmark(ctx, cfg, info)
 			},
 			OnStoppedLeading: func() {
+				err := errors.New("leadership lost")
+				// exit doesn't return, so the deferred end never runs
+				tracing.End(wait, err)
 				log.Error(nil, "leadership lost")
-				os.Exit(1)
+				exit(ctx, cfg, err)
 			},
 			OnNewLeader: func(identity string) {
 				if identity == cfg.Lease.ID {
@@ -109,10 +168,234 @@ func runClient(cfg *config.Config) {
 		},
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
 	// Start the leader election
 	log.Info("starting leader election")
 	leaderelection.RunOrDie(ctx, leaderConfig)
 }
+
+// runBenchmark runs the benchmark unless the test case is already completed
+// and exits the process with its status
+func runBenchmark(ctx context.Context, cfg *config.Config, info *iperf3.Info) {
+	recorder := status.NewRecorder(ctx, cfg.K8sClient, cfg)
+	completion, err := recorder.Completed(ctx, cfg.TestCase)
+	if err != nil {
+		log.Error(err, "failed to check completion")
+		exit(ctx, cfg, err)
+	}
+	if completion != nil && !cfg.ForceRerun {
+		log.Info("test case is already completed, skipping", "completion", completion)
+		metrics.Runs.WithLabelValues(cfg.Mode.String(), metrics.OutcomeSkipped).Inc()
+		exit(ctx, cfg, nil)
+	}
+	if err = benchmark(ctx, cfg, info, recorder); err != nil {
+		log.Error(err, "benchmark failed")
+		metrics.Runs.WithLabelValues(cfg.Mode.String(), metrics.OutcomeFailed).Inc()
+		exit(ctx, cfg, err)
+	}
+	metrics.Runs.WithLabelValues(cfg.Mode.String(), metrics.OutcomeSucceeded).Inc()
+	if err = recorder.MarkCompleted(ctx, cfg.TestCase); err != nil {
+		log.Error(err, "failed to mark the test case completed")
+		exit(ctx, cfg, err)
+	}
+	exit(ctx, cfg, nil)
+}
+
+// exit ends the client span, lingers for the final metrics to be scraped and
+// exits the process with the status of err
+func exit(ctx context.Context, cfg *config.Config, err error) {
+	tracing.End(trace.SpanFromContext(ctx), err)
+	if err := shutdownTracing(context.Background()); err != nil {
+		log.Error(err, "failed to flush traces")
+	}
+	if len(cfg.Metrics.Address) > 0 && cfg.Metrics.Linger > 0 {
+		log.Info("lingering for metrics to be scraped", "duration", cfg.Metrics.Linger)
+		time.Sleep(cfg.Metrics.Linger)
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// benchmark runs the configured benchmark and stores its results
+func benchmark(ctx context.Context, cfg *config.Config, info *iperf3.Info, recorder *status.Recorder) error {
+	if cfg.Mode == config.ModeDNS {
+		return runDNS(ctx, cfg, info.NewRun(), recorder)
+	}
+
+	targets := []target.Target{{Address: cfg.Server, Port: cfg.Port}}
+	kind, _, _, err := cfg.Server.Reference()
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(cfg.Targets.Service) > 0:
+		if targets, err = target.Resolve(ctx, cfg.K8sClient, cfg); err != nil {
+			err = fmt.Errorf("failed to resolve targets: %w", err)
+			recorder.Failed(ctx, info, err)
+			return err
+		}
+	case len(kind) > 0:
+		server, err := target.Discover(ctx, cfg.K8sClient, cfg.Server, cfg.IPFamily, cfg.Port)
+		if err != nil {
+			err = fmt.Errorf("failed to discover server: %w", err)
+			recorder.Failed(ctx, info, err)
+			return err
+		}
+		targets = []target.Target{{Type: config.TargetPod, Address: server.Address, Port: server.Port, Server: server, Addresses: server.Addresses}}
+	}
+	for _, t := range targets {
+		for _, family := range ipFamilies(cfg) {
+			familyTarget, ok := t.ForFamily(family)
+			if !ok {
+				err = fmt.Errorf("%s target %s has no %s address", t.Type, t.Address, family)
+				recorder.Failed(ctx, info, err)
+				return err
+			}
+			if err = runTarget(ctx, cfg.WithIPFamily(family), info, familyTarget, recorder); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ipFamilies lists the IP families to benchmark, dual-stack runs measure
+// IPv4 and IPv6 separately
+func ipFamilies(cfg *config.Config) []config.IPFamily {
+	if cfg.IPFamily == config.IPFamilyDual {
+		return []config.IPFamily{config.IPFamilyIPv4, config.IPFamilyIPv6}
+	}
+	return []config.IPFamily{cfg.IPFamily}
+}
+
+// runDNS runs the DNS benchmark in an execution slot and reports its status
+func runDNS(ctx context.Context, cfg *config.Config, info *iperf3.Info, recorder *status.Recorder) (err error) {
+	ctx, log := withRun(ctx, info)
+	ctx, span := tracing.Start(ctx, "dns", trace.WithAttributes(info.Attributes()...))
+	defer func() { tracing.End(span, err) }()
+
+	release, err := acquireSlot(ctx, cfg, info, recorder)
+	if err != nil {
+		return err
+	}
+	recorder.Started(ctx, info)
+	err = benchmarkDNS(ctx, cfg, info)
+	if err := release(context.Background()); err != nil {
+		log.Error(err, "failed to release the queue slot")
+	}
+	if err != nil {
+		recorder.Failed(ctx, info, err)
+		return err
+	}
+	recorder.Finished(ctx, info, nil)
+	return nil
+}
+
+// runTarget benchmarks a single target in an execution slot and reports its
+// status
+func runTarget(ctx context.Context, cfg *config.Config, info *iperf3.Info, t target.Target, recorder *status.Recorder) (err error) {
+	targetCfg := cfg.WithTarget(t.Address, t.Port)
+	targetInfo := info.NewRun()
+	ctx, log := withRun(ctx, targetInfo)
+	log.Info("benchmarking target", "type", t.Type, "address", t.Address, "port", t.Port, "family", cfg.IPFamily)
+	targetInfo.TargetType = string(t.Type)
+	targetInfo.IPFamily = string(cfg.IPFamily)
+	if t.Server != nil {
+		targetInfo.ServerNodeName = t.Server.NodeName
+		targetInfo.ServerPodIP = t.Server.PodIP
+	}
+	if cfg.K8sClient != nil {
+		if err := targetInfo.Locate(ctx, cfg.K8sClient, cfg); err != nil {
+			log.Error(err, "failed to locate client and server pods, placement is not recorded")
+		}
+	}
+	ctx, span := tracing.Start(ctx, "target", trace.WithAttributes(targetInfo.Attributes()...))
+	defer func() { tracing.End(span, err) }()
+
+	release, err := acquireSlot(ctx, cfg, targetInfo, recorder)
+	if err != nil {
+		return err
+	}
+	recorder.Started(ctx, targetInfo)
+	report, err := benchmarkTarget(ctx, targetCfg, targetInfo, recorder)
+	if err := release(context.Background()); err != nil {
+		log.Error(err, "failed to release the queue slot")
+	}
+	if err != nil {
+		recorder.Failed(ctx, targetInfo, err)
+		return err
+	}
+	recorder.Finished(ctx, targetInfo, report)
+	return nil
+}
+
+// withRun puts a logger tagged with the run ID in the context, so every line
+// logged during the run carries it
+func withRun(ctx context.Context, info *iperf3.Info) (context.Context, logr.Logger) {
+	log := logf.FromContext(ctx).WithValues("run_id", info.RunID)
+	return logf.IntoContext(ctx, log), log
+}
+
+// acquireSlot waits for an execution slot in the cluster-wide queue when it
+// is enabled, the returned function releases it
+func acquireSlot(ctx context.Context, cfg *config.Config, info *iperf3.Info, recorder *status.Recorder) (func(context.Context) error, error) {
+	if len(cfg.Queue.ConfigMap) == 0 {
+		return func(context.Context) error { return nil }, nil
+	}
+	entry := queue.Entry{ID: cfg.Lease.ID, TestCase: info.TestCase}
+	for _, node := range []string{info.ClientNodeName, info.ServerNodeName} {
+		if len(node) > 0 {
+			entry.Nodes = append(entry.Nodes, node)
+		}
+	}
+	log := logf.FromContext(ctx)
+	waitCtx, span := tracing.Start(ctx, "queue.wait")
+	release, err := queue.New(cfg.K8sClient, cfg).Acquire(waitCtx, entry, func(position int) {
+		log.Info("waiting in the benchmark queue", "position", position)
+		span.AddEvent("queued", trace.WithAttributes(attribute.Int("position", position)))
+		recorder.Queued(ctx, info, position)
+	})
+	tracing.End(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get an execution slot: %w", err)
+	}
+	return release, nil
+}
+
+// benchmarkDNS runs the DNS benchmark and stores its results
+func benchmarkDNS(ctx context.Context, cfg *config.Config, info *iperf3.Info) error {
+	_, span := tracing.Start(ctx, "dns.run")
+	report, err := dns.Run(ctx, cfg)
+	tracing.End(span, err)
+	if err != nil {
+		return fmt.Errorf("DNS run failed: %w", err)
+	}
+	logf.FromContext(ctx).Info("saving data")
+	if err = store(ctx, cfg, report, info); err != nil {
+		return fmt.Errorf("metrics upload failed: %w", err)
+	}
+	return nil
+}
+
+// benchmarkTarget runs iperf3 against a single target and stores its results
+func benchmarkTarget(ctx context.Context, cfg *config.Config, info *iperf3.Info, recorder *status.Recorder) (*iperf3.Report, error) {
+	_, span := tracing.Start(ctx, "server.wait")
+	err := iperf3.WaitForServer(ctx, cfg)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed waiting for server: %w", err)
+	}
+	recorder.ServerReachable(ctx, info, string(cfg.Server))
+	_, span = tracing.Start(ctx, "iperf3.run")
+	report, err := iperf3.Run(ctx, cfg)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("iperf3 run failed: %w", err)
+	}
+	logf.FromContext(ctx).Info("saving data")
+	if err = store(ctx, cfg, report, info); err != nil {
+		return nil, fmt.Errorf("metrics upload failed: %w", err)
+	}
+	return report, nil
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"cmp"
+	"cni-benchmark/pkg/clickhouse"
+	"cni-benchmark/pkg/config"
+	"cni-benchmark/pkg/dns"
+	"cni-benchmark/pkg/iperf3"
+	"cni-benchmark/pkg/results"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+)
+
+// Output formats of the result commands
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+func newMigrateCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "migrate",
+		Short: "Create or update the database tables",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg, dialector, err := loadDatabase(cmd)
+			if err != nil {
+				return err
+			}
+			if clickhouse.Accepts(dialector) {
+				client, err := clickhouse.NewClient(cfg, dialector)
+				if err != nil {
+					return err
+				}
+				if err = client.Migrate(cmd.Context()); err != nil {
+					return err
+				}
+			} else {
+				db, err := cfg.Open(dialector)
+				if err != nil {
+					return err
+				}
+				if err = results.Migrate(cmd.Context(), cfg, db); err != nil {
+					return err
+				}
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), "database is migrated")
+			return nil
+		},
+	}
+}
+
+func newReportCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "report",
+		Short: "Summarize stored iperf3 runs, of the test case when it is set",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg, db, err := openDatabase(cmd)
+			if err != nil {
+				return err
+			}
+			labels, _ := cmd.Flags().GetStringToString("label")
+			runs, err := results.Runs(cmd.Context(), db, results.Filter{TestCase: cfg.TestCase, Labels: labels})
+			if err != nil {
+				return err
+			}
+			output, _ := cmd.Flags().GetString("output")
+			if output == outputJSON {
+				return writeJSON(cmd.OutOrStdout(), runs)
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "STARTED\tTEST CASE\tTARGET\tFAMILY\tCNI\tRUN ID\tINTERVALS\tMEAN\tMIN\tMAX\tRETRANSMITS")
+			for _, run := range runs {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\t%d\n",
+					run.StartedAt.Format(time.RFC3339), run.TestCase, run.TargetType, cmp.Or(run.IPFamily, "-"), run.CNIName, run.RunID,
+					run.Intervals, formatBps(run.MeanBps), formatBps(run.MinBps), formatBps(run.MaxBps), run.Retransmits)
+			}
+			return w.Flush()
+		},
+	}
+	addResultFlags(cmd)
+	return cmd
+}
+
+func newCompareCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "compare BASELINE CANDIDATE",
+		Short: "Compare the throughput of two test cases per target type",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, db, err := openDatabase(cmd)
+			if err != nil {
+				return err
+			}
+			labels, _ := cmd.Flags().GetStringToString("label")
+			comparisons, err := results.Compare(cmd.Context(), db,
+				results.Filter{TestCase: args[0], Labels: labels},
+				results.Filter{TestCase: args[1], Labels: labels})
+			if err != nil {
+				return err
+			}
+			output, _ := cmd.Flags().GetString("output")
+			if output == outputJSON {
+				return writeJSON(cmd.OutOrStdout(), comparisons)
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintf(w, "TARGET\t%s\tRUNS\t%s\tRUNS\tCHANGE\n", args[0], args[1])
+			for _, c := range comparisons {
+				fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%d\t%+.1f%%\n", c.TargetType,
+					formatBps(c.BaselineBps), c.BaselineRuns, formatBps(c.CandidateBps), c.CandidateRuns, c.ChangePercent)
+			}
+			return w.Flush()
+		},
+	}
+	addResultFlags(cmd)
+	return cmd
+}
+
+func newReplayCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "replay FILE...",
+		Short: "Store saved iperf3 --json or --json-stream output as new runs of the test case",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, files []string) error {
+			cfg, err := load(cmd, map[string]any{"mode": config.ModeClient.String()})
+			if err != nil {
+				return err
+			}
+			if err = setupLogging(cfg); err != nil {
+				return err
+			}
+			if !cfg.Standalone {
+				if cfg.K8sClient, err = config.BuildKubernetesClient(); err != nil {
+					return fmt.Errorf("failed to build kubernetes client: %w", err)
+				}
+			}
+			info := &iperf3.Info{}
+			if err = info.Build(cmd.Context(), cfg); err != nil {
+				return fmt.Errorf("failed to gather information: %w", err)
+			}
+			for _, file := range files {
+				output, err := os.ReadFile(file)
+				if err != nil {
+					return err
+				}
+				report, err := iperf3.ParseReport(output)
+				if err != nil {
+					return fmt.Errorf("%s: %w", file, err)
+				}
+				run := info.NewRun()
+				if err = store(cmd.Context(), cfg, report, run); err != nil {
+					return fmt.Errorf("%s: %w", file, err)
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "%s stored as run %s\n", file, run.RunID)
+			}
+			return nil
+		},
+	}
+}
+
+func addResultFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.StringToString("label", nil, "only runs with the label, repeat for more")
+	flags.StringP("output", "o", outputTable, "output format: table or json")
+	bindKeys(flags, map[string]string{"label": "", "output": ""})
+	completeValues(cmd, "output", outputTable, outputJSON)
+}
+
+// openDatabase connects to the configured database
+func openDatabase(cmd *cobra.Command) (*config.Config, *gorm.DB, error) {
+	cfg, dialector, err := loadDatabase(cmd)
+	if err != nil {
+		return nil, nil, err
+	}
+	db, err := cfg.Open(dialector)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cfg, db, nil
+}
+
+// loadDatabase loads the configuration and resolves the database URL
+func loadDatabase(cmd *cobra.Command) (*config.Config, gorm.Dialector, error) {
+	cfg, err := load(cmd, map[string]any{"mode": config.ModeClient.String()})
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = setupLogging(cfg); err != nil {
+		return nil, nil, err
+	}
+	if err = buildSecretClient(cfg); err != nil {
+		return nil, nil, err
+	}
+	dialector, err := cfg.Dialector(cmd.Context())
+	if err != nil {
+		return nil, nil, err
+	}
+	return cfg, dialector, nil
+}
+
+// store saves an iperf3 or DNS run in ClickHouse's own layout when the
+// database URL points to it, through the SQL tables otherwise
+func store(ctx context.Context, cfg *config.Config, report any, info *iperf3.Info) error {
+	// A failure to resolve the credentials is retried by the SQL stores
+	dialector, err := cfg.Dialector(ctx)
+	toClickHouse := err == nil && clickhouse.Accepts(dialector)
+	switch report := report.(type) {
+	case *iperf3.Report:
+		if toClickHouse {
+			return clickhouse.Store(ctx, cfg, report, info)
+		}
+		return iperf3.Store(ctx, cfg, report, info)
+	case *dns.Report:
+		if toClickHouse {
+			return clickhouse.StoreDNS(ctx, cfg, report, info)
+		}
+		return dns.Store(ctx, cfg, report, info)
+	default:
+		return fmt.Errorf("unsupported report %T", report)
+	}
+}
+
+// buildSecretClient builds the Kubernetes client reading the database URL
+// from a Secret
+func buildSecretClient(cfg *config.Config) (err error) {
+	if cfg.DatabaseCredential == nil || cfg.DatabaseCredential.Secret == nil {
+		return nil
+	}
+	if cfg.K8sClient, err = config.BuildKubernetesClient(); err != nil {
+		return fmt.Errorf("failed to build kubernetes client: %w", err)
+	}
+	return nil
+}
+
+func writeJSON(w io.Writer, value any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// formatBps formats a throughput with a decimal unit prefix
+func formatBps(bps float64) string {
+	for _, unit := range []string{"bit/s", "kbit/s", "Mbit/s", "Gbit/s"} {
+		if bps < 1000 {
+			return fmt.Sprintf("%.2f %s", bps, unit)
+		}
+		bps /= 1000
+	}
+	return fmt.Sprintf("%.2f Tbit/s", bps)
+}
@@ -0,0 +1,18 @@
+// Command schema prints the JSON Schema of the configuration file
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"cni-benchmark/pkg/config"
+)
+
+func main() {
+	schema, err := config.Schema()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Println(string(schema))
+}
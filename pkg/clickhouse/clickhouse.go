@@ -0,0 +1,148 @@
+package clickhouse
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"gorm.io/driver/clickhouse"
+	"gorm.io/gorm"
+
+	config "cni-benchmark/pkg/config"
+)
+
+// batchSize is the number of rows sent in a single INSERT
+var batchSize = 10000
+
+// Accepts tells whether the dialector points to ClickHouse
+func Accepts(dialector gorm.Dialector) bool {
+	_, ok := dialector.(*clickhouse.Dialector)
+	return ok
+}
+
+// Client runs queries through the ClickHouse HTTP interface
+type Client struct {
+	endpoint string
+	database string
+	user     string
+	password string
+	http     *http.Client
+	tables   *config.Database
+}
+
+// NewClient builds a client for the connection string of the dialector with
+// the TLS, timeout and table naming settings of the configuration
+func NewClient(cfg *config.Config, dialector gorm.Dialector) (*Client, error) {
+	dsn, err := url.Parse(config.DatabaseDSN(dialector))
+	if err != nil {
+		return nil, fmt.Errorf("invalid clickhouse DSN: %w", err)
+	}
+	params := dsn.Query()
+	client := &Client{
+		database: strings.TrimPrefix(dsn.Path, "/"),
+		user:     dsn.User.Username(),
+		tables:   &cfg.Database,
+	}
+	client.password, _ = dsn.User.Password()
+	if user := params.Get("username"); len(user) > 0 {
+		client.user = user
+	}
+	if password := params.Get("password"); len(password) > 0 {
+		client.password = password
+	}
+	if len(client.database) == 0 {
+		client.database = "default"
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.Database.ConnectTimeout > 0 {
+		transport.DialContext = (&net.Dialer{Timeout: cfg.Database.ConnectTimeout}).DialContext
+	}
+	if dsn.Scheme == "https" {
+		skipVerify, _ := strconv.ParseBool(params.Get("skip_verify"))
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: skipVerify}
+	}
+	if cfg.Database.TLS.Enabled() {
+		if transport.TLSClientConfig, err = cfg.Database.TLS.Config(dsn.Hostname()); err != nil {
+			return nil, err
+		}
+		dsn.Scheme = "https"
+		if transport.TLSClientConfig == nil {
+			dsn.Scheme = "http"
+		}
+	}
+	client.http = &http.Client{Transport: transport}
+	client.endpoint = (&url.URL{Scheme: dsn.Scheme, Host: dsn.Host, Path: "/"}).String()
+	return client, nil
+}
+
+// Exec runs the query, the body carries the data of an INSERT
+func (c *Client) Exec(ctx context.Context, query string, body io.Reader, settings url.Values) error {
+	params := url.Values{"database": {c.database}, "query": {query}}
+	for key, values := range settings {
+		params[key] = values
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint+"?"+params.Encode(), body)
+	if err != nil {
+		return err
+	}
+	if len(c.user) > 0 {
+		request.Header.Set("X-ClickHouse-User", c.user)
+	}
+	if len(c.password) > 0 {
+		request.Header.Set("X-ClickHouse-Key", c.password)
+	}
+	response, err := c.http.Do(request)
+	if err != nil {
+		return fmt.Errorf("failed to reach clickhouse: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 4096))
+		return fmt.Errorf("clickhouse returned %s: %s", response.Status, strings.TrimSpace(string(message)))
+	}
+	_, err = io.Copy(io.Discard, response.Body)
+	return err
+}
+
+// Insert writes the rows in batches of JSONEachRow. Every batch carries a
+// deduplication token derived from the key, so a retried batch which already
+// made it is not inserted twice.
+func (c *Client) Insert(ctx context.Context, table, key string, rows []map[string]any) error {
+	for start := 0; start < len(rows); start += batchSize {
+		var body bytes.Buffer
+		encoder := json.NewEncoder(&body)
+		for _, row := range rows[start:min(start+batchSize, len(rows))] {
+			if err := encoder.Encode(row); err != nil {
+				return fmt.Errorf("failed to encode a %s row: %w", table, err)
+			}
+		}
+		settings := url.Values{
+			"insert_deduplicate":         {"1"},
+			"insert_deduplication_token": {fmt.Sprintf("%s-%s-%d", table, key, start)},
+		}
+		query := fmt.Sprintf("INSERT INTO %s FORMAT JSONEachRow", c.table(table))
+		if err := c.Exec(ctx, query, &body, settings); err != nil {
+			return fmt.Errorf("failed to insert into %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// table returns the quoted name of the table with the configured schema and
+// prefix
+func (c *Client) table(name string) string {
+	parts := strings.Split(c.tables.Table(name), ".")
+	for i, part := range parts {
+		parts[i] = "`" + strings.ReplaceAll(part, "`", "\\`") + "`"
+	}
+	return strings.Join(parts, ".")
+}
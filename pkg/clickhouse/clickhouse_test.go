@@ -0,0 +1,272 @@
+package clickhouse_test
+
+import (
+	"bufio"
+	"cni-benchmark/pkg/clickhouse"
+	"cni-benchmark/pkg/config"
+	"cni-benchmark/pkg/detect"
+	"cni-benchmark/pkg/dns"
+	"cni-benchmark/pkg/iperf3"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/utils/ptr"
+)
+
+func TestClickHouse(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "ClickHouse")
+}
+
+// query is a request received by the stand-in
+type query struct {
+	SQL      string
+	Settings map[string]string
+	User     string
+	Key      string
+	Rows     []map[string]any
+}
+
+// standIn records the queries sent to the ClickHouse HTTP interface and
+// fails the first ones when asked to
+type standIn struct {
+	*httptest.Server
+	mu       sync.Mutex
+	queries  []query
+	failures int
+}
+
+func newStandIn() *standIn {
+	s := &standIn{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.failures > 0 {
+			s.failures--
+			http.Error(w, "Code: 252. DB::Exception: Too many parts", http.StatusInternalServerError)
+			return
+		}
+		q := query{
+			SQL:      r.URL.Query().Get("query"),
+			Settings: map[string]string{},
+			User:     r.Header.Get("X-ClickHouse-User"),
+			Key:      r.Header.Get("X-ClickHouse-Key"),
+		}
+		for key := range r.URL.Query() {
+			q.Settings[key] = r.URL.Query().Get(key)
+		}
+		scanner := bufio.NewScanner(r.Body)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			row := map[string]any{}
+			if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			q.Rows = append(q.Rows, row)
+		}
+		s.queries = append(s.queries, q)
+		_, _ = io.WriteString(w, "")
+	}))
+	return s
+}
+
+// matching returns the queries starting with the prefix
+func (s *standIn) matching(prefix string) []query {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var queries []query
+	for _, q := range s.queries {
+		if strings.HasPrefix(q.SQL, prefix) {
+			queries = append(queries, q)
+		}
+	}
+	return queries
+}
+
+// report returns an iperf3 report with the number of one second intervals
+func report(intervals int) *iperf3.Report {
+	var output strings.Builder
+	output.WriteString(`{"start": {"version": "iperf 3.17", "timestamp": {"timesecs": 1700000000},
+		"connected": [{"remote_host": "10.0.0.2"}], "test_start": {"protocol": "TCP"}}, "intervals": [`)
+	for i := range intervals {
+		if i > 0 {
+			output.WriteString(",")
+		}
+		fmt.Fprintf(&output, `{"sum": {"start": %d, "end": %d, "seconds": 1, "bytes": 1000, "bits_per_second": %d}}`, i, i+1, 1000*(i+1))
+	}
+	output.WriteString(`], "end": {"sum_sent": {"seconds": 2, "bytes": 3000, "bits_per_second": 1500, "retransmits": 4},
+		"sum_received": {"seconds": 2, "bytes": 2900, "bits_per_second": 1450}}}`)
+	report, err := iperf3.ParseReport([]byte(output.String()))
+	Expect(err).ToNot(HaveOccurred())
+	return report
+}
+
+var _ = Describe("ClickHouse", func() {
+	var server *standIn
+	var cfg *config.Config
+
+	BeforeEach(func() {
+		server = newStandIn()
+		DeferCleanup(server.Close)
+		dialector, err := config.OpenDialector("clickhouse://bench:secret@" + server.Listener.Addr().String() + "/benchmarks")
+		Expect(err).ToNot(HaveOccurred())
+		cfg = &config.Config{DatabaseDialector: dialector}
+	})
+
+	It("should only accept ClickHouse URLs", func() {
+		Expect(clickhouse.Accepts(cfg.DatabaseDialector)).To(BeTrue())
+		dialector, err := config.OpenDialector("sqlite://:memory:")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(clickhouse.Accepts(dialector)).To(BeFalse())
+	})
+
+	It("should create partitioned and ordered tables", func() {
+		cfg.Database.TablePrefix = "bench_"
+		client, err := clickhouse.NewClient(cfg, cfg.DatabaseDialector)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(client.Migrate(context.Background())).To(Succeed())
+
+		tables := server.matching("CREATE TABLE IF NOT EXISTS")
+		Expect(tables).To(HaveLen(5))
+		Expect(tables[0].SQL).To(ContainSubstring("`bench_metrics`"))
+		Expect(tables[0].SQL).To(ContainSubstring("ENGINE = MergeTree"))
+		Expect(tables[0].SQL).To(ContainSubstring("PARTITION BY toYYYYMM(timestamp)"))
+		Expect(tables[0].SQL).To(ContainSubstring("ORDER BY (test_case, cni_name, target_type, run_id, timestamp)"))
+		Expect(tables[0].SQL).To(ContainSubstring("`cni_name` LowCardinality(String)"))
+		Expect(tables[0].SQL).To(ContainSubstring("`run_id` String"))
+		Expect(tables[0].SQL).To(ContainSubstring("`same_node` Nullable(Bool)"))
+		Expect(tables[0].SQL).ToNot(ContainSubstring("`host`"))
+		Expect(tables[1].SQL).To(ContainSubstring("`bench_runs`"))
+		Expect(tables[1].SQL).To(ContainSubstring("ENGINE = ReplacingMergeTree"))
+		Expect(tables[1].SQL).To(ContainSubstring("PARTITION BY toYYYYMM(started_at)"))
+		Expect(tables[2].SQL).To(ContainSubstring("`bench_labels`"))
+		Expect(tables[3].SQL).To(ContainSubstring("`bench_hosts`"))
+		Expect(tables[3].SQL).To(ContainSubstring("ORDER BY run_id"))
+		Expect(tables[4].SQL).To(ContainSubstring("`bench_dns_metrics`"))
+		Expect(tables[4].SQL).To(ContainSubstring("ORDER BY (test_case, cni_name, run_id, name, timestamp)"))
+		Expect(tables[4].SQL).To(ContainSubstring("`cni_name` LowCardinality(String)"))
+		for _, table := range tables {
+			Expect(table.Settings).To(HaveKeyWithValue("database", "benchmarks"))
+			Expect(table.User).To(Equal("bench"))
+			Expect(table.Key).To(Equal("secret"))
+		}
+	})
+
+	It("should store intervals, the run summary, labels and the host", func() {
+		info := (&iperf3.Info{TestCase: "baseline", CNIName: "cilium", SameNode: ptr.To(true),
+			Labels: map[string]string{"mtu": "9000"}, Host: &detect.Host{CongestionControl: "bbr"}}).NewRun()
+		Expect(clickhouse.Store(context.Background(), cfg, report(2), info)).To(Succeed())
+
+		metrics := server.matching("INSERT INTO `metrics`")
+		Expect(metrics).To(HaveLen(1))
+		Expect(metrics[0].Rows).To(HaveLen(2))
+		Expect(metrics[0].Settings).To(HaveKeyWithValue("insert_deduplication_token", "metrics-"+info.RunID+"-0"))
+		Expect(metrics[0].Rows[1]).To(HaveKeyWithValue("timestamp", "2023-11-14 22:13:21.000"))
+		Expect(metrics[0].Rows[1]).To(HaveKeyWithValue("bandwidth_bps", 2000.0))
+		Expect(metrics[0].Rows[1]).To(HaveKeyWithValue("cni_name", "cilium"))
+		Expect(metrics[0].Rows[1]).To(HaveKeyWithValue("same_node", true))
+		Expect(metrics[0].Rows[1]).To(HaveKeyWithValue("cross_zone", BeNil()))
+		Expect(metrics[0].Rows[1]).To(HaveKeyWithValue("ip_family", "ipv4"))
+		Expect(metrics[0].Rows[1]).To(HaveKeyWithValue("iperf3_version", "iperf 3.17"))
+		Expect(metrics[0].Rows[1]).ToNot(HaveKey("host"))
+
+		runs := server.matching("INSERT INTO `runs`")
+		Expect(runs).To(HaveLen(1))
+		Expect(runs[0].Rows).To(ConsistOf(SatisfyAll(
+			HaveKeyWithValue("run_id", info.RunID),
+			HaveKeyWithValue("started_at", "2023-11-14 22:13:20.000"),
+			HaveKeyWithValue("intervals", 2.0),
+			HaveKeyWithValue("mean_bps", 1500.0),
+			HaveKeyWithValue("min_bps", 1000.0),
+			HaveKeyWithValue("max_bps", 2000.0),
+			HaveKeyWithValue("received_bytes", 2900.0),
+			HaveKeyWithValue("retransmits", 4.0),
+		)))
+
+		labels := server.matching("INSERT INTO `labels`")
+		Expect(labels).To(HaveLen(1))
+		Expect(labels[0].Rows).To(ConsistOf(map[string]any{"run_id": info.RunID, "key": "mtu", "value": "9000"}))
+
+		hosts := server.matching("INSERT INTO `hosts`")
+		Expect(hosts).To(HaveLen(1))
+		Expect(hosts[0].Rows).To(ConsistOf(SatisfyAll(
+			HaveKeyWithValue("run_id", info.RunID),
+			HaveKeyWithValue("snapshot", ContainSubstring(`"congestion_control":"bbr"`)),
+		)))
+	})
+
+	It("should store DNS intervals, labels and the host", func() {
+		info := (&iperf3.Info{TestCase: "dns", CNIName: "cilium", Labels: map[string]string{"mtu": "9000"}}).NewRun()
+		report := &dns.Report{Start: time.Unix(1700000000, 0), Samples: []dns.Sample{
+			{Name: "kubernetes.default", Latency: 2 * time.Millisecond},
+			{Name: "kubernetes.default", Offset: 500 * time.Millisecond, Result: dns.ResultTimeout},
+		}}
+		Expect(clickhouse.StoreDNS(context.Background(), cfg, report, info)).To(Succeed())
+
+		metrics := server.matching("INSERT INTO `dns_metrics`")
+		Expect(metrics).To(HaveLen(1))
+		Expect(metrics[0].Settings).To(HaveKeyWithValue("insert_deduplication_token", "dns_metrics-"+info.RunID+"-0"))
+		Expect(metrics[0].Rows).To(ConsistOf(SatisfyAll(
+			HaveKeyWithValue("timestamp", "2023-11-14 22:13:20.000"),
+			HaveKeyWithValue("name", "kubernetes.default"),
+			HaveKeyWithValue("queries", 2.0),
+			HaveKeyWithValue("timeouts", 1.0),
+			HaveKeyWithValue("latency_max_ms", 2.0),
+			HaveKeyWithValue("cni_name", "cilium"),
+			HaveKeyWithValue("iperf3_protocol", "dns"),
+		)))
+		labels := server.matching("INSERT INTO `labels`")
+		Expect(labels).To(HaveLen(1))
+		Expect(labels[0].Rows).To(ConsistOf(map[string]any{"run_id": info.RunID, "key": "mtu", "value": "9000"}))
+		Expect(server.matching("INSERT INTO `hosts`")).To(BeEmpty())
+		Expect(server.matching("INSERT INTO `metrics`")).To(BeEmpty())
+		Expect(server.matching("INSERT INTO `runs`")).To(BeEmpty())
+	})
+
+	It("should insert large runs in batches", func() {
+		info := (&iperf3.Info{TestCase: "long"}).NewRun()
+		Expect(clickhouse.Store(context.Background(), cfg, report(10001), info)).To(Succeed())
+
+		metrics := server.matching("INSERT INTO `metrics`")
+		Expect(metrics).To(HaveLen(2))
+		Expect(metrics[0].Rows).To(HaveLen(10000))
+		Expect(metrics[1].Rows).To(HaveLen(1))
+		Expect(metrics[1].Settings).To(HaveKeyWithValue("insert_deduplicate", "1"))
+		Expect(metrics[1].Settings).To(HaveKeyWithValue("insert_deduplication_token", "metrics-"+info.RunID+"-10000"))
+	})
+
+	It("should retry with the same deduplication tokens", func() {
+		server.failures = 2
+		info := (&iperf3.Info{TestCase: "retry"}).NewRun()
+		Expect(clickhouse.Store(context.Background(), cfg, report(1), info)).To(Succeed())
+		Expect(server.matching("INSERT INTO `metrics`")).To(HaveLen(1))
+		Expect(server.matching("INSERT INTO `runs`")).To(HaveLen(1))
+	})
+
+	It("should report errors of the server", func() {
+		server.failures = 1
+		client, err := clickhouse.NewClient(cfg, cfg.DatabaseDialector)
+		Expect(err).ToNot(HaveOccurred())
+		err = client.Exec(context.Background(), "SELECT 1", nil, nil)
+		Expect(err).To(MatchError(ContainSubstring("Too many parts")))
+	})
+
+	It("should give up on unreachable servers", func() {
+		server.Close()
+		cfg.Database.ConnectTimeout = 100 * time.Millisecond
+		client, err := clickhouse.NewClient(cfg, cfg.DatabaseDialector)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(client.Exec(context.Background(), "SELECT 1", nil, nil)).ToNot(Succeed())
+	})
+})
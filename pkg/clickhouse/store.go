@@ -0,0 +1,335 @@
+package clickhouse
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm/schema"
+
+	config "cni-benchmark/pkg/config"
+	"cni-benchmark/pkg/dns"
+	"cni-benchmark/pkg/iperf3"
+	"cni-benchmark/pkg/metrics"
+	"cni-benchmark/pkg/tracing"
+)
+
+// Table names before the configured schema and prefix, the same as the SQL
+// sink uses so queries work against both
+const (
+	MetricsTable    = "metrics"
+	RunsTable       = "runs"
+	LabelsTable     = "labels"
+	HostsTable      = "hosts"
+	DNSMetricsTable = "dns_metrics"
+)
+
+// timeFormat is how DateTime64(3) values are written in JSONEachRow
+const timeFormat = "2006-01-02 15:04:05.000"
+
+// column is a run dimension taken from iperf3.Info
+type column struct {
+	field *schema.Field
+	kind  string
+}
+
+// dimensions lists the Info columns with the names the SQL sink gives them.
+// Strings are LowCardinality as runs share most of them.
+var dimensions = sync.OnceValues(func() ([]column, error) {
+	info, err := schema.Parse(&iperf3.Info{}, &sync.Map{}, schema.NamingStrategy{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse run info: %w", err)
+	}
+	columns := make([]column, 0, len(info.Fields))
+	for _, field := range info.Fields {
+		if len(field.DBName) == 0 {
+			continue
+		}
+		kind := "LowCardinality(String)"
+		switch {
+		case field.DBName == "run_id":
+			kind = "String"
+		case field.FieldType.Kind() == reflect.Bool:
+			kind = "Bool"
+		case field.FieldType == reflect.TypeFor[*bool]():
+			kind = "Nullable(Bool)"
+		case field.FieldType.Kind() != reflect.String:
+			return nil, fmt.Errorf("no ClickHouse type for %s", field.Name)
+		}
+		columns = append(columns, column{field, kind})
+	}
+	return columns, nil
+})
+
+// Migrate creates the partitioned and ordered tables of intervals, run
+// summaries, labels, host snapshots and DNS intervals
+func (c *Client) Migrate(ctx context.Context) error {
+	columns, err := dimensions()
+	if err != nil {
+		return err
+	}
+	var info strings.Builder
+	for _, column := range columns {
+		fmt.Fprintf(&info, "\n\t`%s` %s,", column.field.DBName, column.kind)
+	}
+	for _, query := range []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	timestamp DateTime64(3, 'UTC'),%s
+	bandwidth_bps Float64,
+	bytes UInt64,
+	duration_seconds Float64,
+	retransmits UInt64,
+	interval_start Float64,
+	interval_end Float64
+) ENGINE = MergeTree
+PARTITION BY toYYYYMM(timestamp)
+ORDER BY (test_case, cni_name, target_type, run_id, timestamp)
+SETTINGS non_replicated_deduplication_window = 1000`, c.table(MetricsTable), info.String()),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	started_at DateTime64(3, 'UTC'),%s
+	intervals UInt32,
+	mean_bps Float64,
+	min_bps Float64,
+	max_bps Float64,
+	sent_bytes UInt64,
+	received_bytes UInt64,
+	received_bps Float64,
+	retransmits UInt64,
+	duration_seconds Float64
+) ENGINE = ReplacingMergeTree
+PARTITION BY toYYYYMM(started_at)
+ORDER BY (test_case, cni_name, target_type, run_id)`, c.table(RunsTable), info.String()),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	run_id String,
+	key LowCardinality(String),
+	value String
+) ENGINE = ReplacingMergeTree
+ORDER BY (key, value, run_id)`, c.table(LabelsTable)),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	run_id String,
+	snapshot String
+) ENGINE = ReplacingMergeTree
+ORDER BY run_id`, c.table(HostsTable)),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	timestamp DateTime64(3, 'UTC'),%s
+	name LowCardinality(String),
+	queries UInt64,
+	timeouts UInt64,
+	serv_fails UInt64,
+	failures UInt64,
+	latency_p50_ms Float64,
+	latency_p90_ms Float64,
+	latency_p99_ms Float64,
+	latency_max_ms Float64,
+	interval_start Float64,
+	interval_end Float64
+) ENGINE = MergeTree
+PARTITION BY toYYYYMM(timestamp)
+ORDER BY (test_case, cni_name, run_id, name, timestamp)
+SETTINGS non_replicated_deduplication_window = 1000`, c.table(DNSMetricsTable), info.String()),
+	} {
+		if err := c.Exec(ctx, query, nil, nil); err != nil {
+			return fmt.Errorf("failed to migrate clickhouse: %w", err)
+		}
+	}
+	return nil
+}
+
+// Store pushes iperf3 intervals, the run summary, labels and the host
+// snapshot to ClickHouse
+func Store(ctx context.Context, cfg *config.Config, report *iperf3.Report, info *iperf3.Info) (err error) {
+	if cfg == nil {
+		return errors.New("configuration is required")
+	}
+	ctx, span := tracing.Start(ctx, "store", trace.WithAttributes(info.Attributes()...))
+	defer func() { tracing.End(span, err) }()
+
+	intervals := iperf3.BuildMetrics(cfg, report, info)
+	metricRows, err := metricRows(intervals)
+	if err != nil {
+		return err
+	}
+	runRow, err := runRow(report, info, intervals)
+	if err != nil {
+		return err
+	}
+
+	return push(ctx, cfg, func(client *Client) error {
+		if err := client.Insert(ctx, MetricsTable, info.RunID, metricRows); err != nil {
+			return err
+		}
+		if err := client.insertRun(ctx, info); err != nil {
+			return err
+		}
+		// The summary goes last, a run listed there has all its rows stored
+		return client.Insert(ctx, RunsTable, info.RunID, []map[string]any{runRow})
+	})
+}
+
+// StoreDNS pushes DNS intervals, labels and the host snapshot to ClickHouse
+func StoreDNS(ctx context.Context, cfg *config.Config, report *dns.Report, info *iperf3.Info) (err error) {
+	if cfg == nil {
+		return errors.New("configuration is required")
+	}
+	ctx, span := tracing.Start(ctx, "store", trace.WithAttributes(info.Attributes()...))
+	defer func() { tracing.End(span, err) }()
+
+	rows, err := dnsMetricRows(dns.BuildMetrics(cfg, report, info))
+	if err != nil {
+		return err
+	}
+	return push(ctx, cfg, func(client *Client) error {
+		if err := client.Insert(ctx, DNSMetricsTable, info.RunID, rows); err != nil {
+			return err
+		}
+		return client.insertRun(ctx, info)
+	})
+}
+
+// push migrates the tables and runs insert with retries. The client is
+// reused across attempts until the resolved URL changes.
+func push(ctx context.Context, cfg *config.Config, insert func(client *Client) error) error {
+	var client *Client
+	var dsn string
+	operation := func() error {
+		dialector, err := cfg.Dialector(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to resolve database credentials: %w", err)
+		}
+		if resolved := config.DatabaseDSN(dialector); client == nil || resolved != dsn {
+			if client, err = NewClient(cfg, dialector); err != nil {
+				return backoff.Permanent(err)
+			}
+			dsn = resolved
+		}
+		if err = client.Migrate(ctx); err != nil {
+			return err
+		}
+		return insert(client)
+	}
+
+	return metrics.RetryStore(ctx, "clickhouse", operation)
+}
+
+// insertRun pushes the labels and the host snapshot of the run
+func (c *Client) insertRun(ctx context.Context, info *iperf3.Info) error {
+	labels := make([]map[string]any, 0, len(info.Labels))
+	for key, value := range info.Labels {
+		labels = append(labels, map[string]any{"run_id": info.RunID, "key": key, "value": value})
+	}
+	if err := c.Insert(ctx, LabelsTable, info.RunID, labels); err != nil {
+		return err
+	}
+	hosts, err := hostRows(info)
+	if err != nil {
+		return err
+	}
+	return c.Insert(ctx, HostsTable, info.RunID, hosts)
+}
+
+// infoRow returns the run dimensions of a row
+func infoRow(info *iperf3.Info) (map[string]any, error) {
+	columns, err := dimensions()
+	if err != nil {
+		return nil, err
+	}
+	value := reflect.ValueOf(info).Elem()
+	row := make(map[string]any, len(columns)+10)
+	for _, column := range columns {
+		row[column.field.DBName] = value.FieldByIndex(column.field.StructField.Index).Interface()
+	}
+	return row, nil
+}
+
+// hostRows returns the host snapshot row of the run, if any
+func hostRows(info *iperf3.Info) ([]map[string]any, error) {
+	if info.Host == nil {
+		return nil, nil
+	}
+	snapshot, err := json.Marshal(info.Host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode host snapshot: %w", err)
+	}
+	return []map[string]any{{"run_id": info.RunID, "snapshot": string(snapshot)}}, nil
+}
+
+// metricRows returns a row per interval
+func metricRows(intervals []*iperf3.Metric) ([]map[string]any, error) {
+	rows := make([]map[string]any, 0, len(intervals))
+	for _, interval := range intervals {
+		row, err := infoRow(&interval.Info)
+		if err != nil {
+			return nil, err
+		}
+		row["timestamp"] = interval.Timestamp.UTC().Format(timeFormat)
+		row["bandwidth_bps"] = interval.BandwidthBps
+		row["bytes"] = interval.Bytes
+		row["duration_seconds"] = interval.DurationSeconds
+		row["retransmits"] = interval.Retransmits
+		row["interval_start"] = interval.IntervalStart
+		row["interval_end"] = interval.IntervalEnd
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// dnsMetricRows returns a row per DNS interval
+func dnsMetricRows(intervals []*dns.Metric) ([]map[string]any, error) {
+	rows := make([]map[string]any, 0, len(intervals))
+	for _, interval := range intervals {
+		row, err := infoRow(&interval.Info)
+		if err != nil {
+			return nil, err
+		}
+		row["timestamp"] = interval.Timestamp.UTC().Format(timeFormat)
+		row["name"] = interval.Name
+		row["queries"] = interval.Queries
+		row["timeouts"] = interval.Timeouts
+		row["serv_fails"] = interval.ServFails
+		row["failures"] = interval.Failures
+		row["latency_p50_ms"] = interval.LatencyP50Ms
+		row["latency_p90_ms"] = interval.LatencyP90Ms
+		row["latency_p99_ms"] = interval.LatencyP99Ms
+		row["latency_max_ms"] = interval.LatencyMaxMs
+		row["interval_start"] = interval.IntervalStart
+		row["interval_end"] = interval.IntervalEnd
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// runRow summarizes the run from its intervals and the end of the report
+func runRow(report *iperf3.Report, info *iperf3.Info, intervals []*iperf3.Metric) (map[string]any, error) {
+	row, err := infoRow(info)
+	if err != nil {
+		return nil, err
+	}
+	startedAt := time.Unix(int64(report.Start.Timestamp.Seconds), 0)
+	var mean, minBps, maxBps float64
+	for i, interval := range intervals {
+		if i == 0 {
+			startedAt, minBps = interval.Timestamp, interval.BandwidthBps
+		}
+		mean += (interval.BandwidthBps - mean) / float64(i+1)
+		minBps = min(minBps, interval.BandwidthBps)
+		maxBps = max(maxBps, interval.BandwidthBps)
+	}
+	row["started_at"] = startedAt.UTC().Format(timeFormat)
+	row["intervals"] = len(intervals)
+	row["mean_bps"] = mean
+	row["min_bps"] = minBps
+	row["max_bps"] = maxBps
+	row["sent_bytes"] = report.End.Sent.Bytes
+	row["received_bytes"] = report.End.Received.Bytes
+	row["received_bps"] = report.End.Received.BitsPerSecond
+	row["retransmits"] = report.End.Sent.Retransmits
+	row["duration_seconds"] = report.End.Sent.DurationSeconds
+	return row, nil
+}
@@ -1,45 +1,176 @@
 package config
 
 import (
+	"cmp"
+	"context"
 	"errors"
 	"fmt"
+	"maps"
+	"math"
+	"net"
+	"net/netip"
 	"os"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection"
+
+	"cni-benchmark/pkg/logging"
 )
 
-// Build initializes the Config by loading from environment variables.
+// FileFlag is the flag with the configuration file path, it is not bound to a
+// configuration key
+const FileFlag = "config"
+
+// KeyAnnotation is the flag annotation holding the configuration key the flag
+// is bound to, like log.level for --log-level, an empty key leaves it unbound
+const KeyAnnotation = "cni-benchmark/config-key"
+
+// Source tells where to read the configuration from besides environment
+// variables. Flags override environment variables, which override the file.
+type Source struct {
+	// YAML or JSON file, defaults to CONFIG_FILE
+	File string
+	// Flags bound to the key in their KeyAnnotation, or else named after the
+	// key with dashes for underscores like --test-case
+	Flags *pflag.FlagSet
+	// Values overriding everything else, like the mode of a subcommand
+	Overrides map[string]any
+}
+
+// Build initializes the Config by loading from environment variables and the
+// file in CONFIG_FILE.
 func Build() (cfg *Config, err error) {
+	return BuildFrom(Source{})
+}
+
+// BuildFrom initializes the Config from the source and environment variables.
+// All decoding and validation errors are reported at once.
+func BuildFrom(src Source) (cfg *Config, err error) {
 	cfg = &Config{
-		viper:     viper.NewWithOptions(viper.EnvKeyReplacer(&envReplacer{})),
-		Port:      5201,
-		Lease:     Lease{Namespace: "default", Name: "cni-benchmark"},
+		viper: viper.NewWithOptions(viper.EnvKeyReplacer(&envReplacer{})),
+		Port:  5201,
+		Lease: Lease{
+			Namespace:     "default",
+			Name:          "cni-benchmark",
+			Lock:          LockLeases,
+			Duration:      20 * time.Second,
+			RenewDeadline: 10 * time.Second,
+			RetryPeriod:   time.Second,
+		},
 		Args:      Args{},
 		AlignTime: true,
 		Duration:  10,
 		Command:   []string{"iperf3"},
+		DNS:       DNS{Interval: 100 * time.Millisecond, Timeout: 2 * time.Second},
+		Targets:   Targets{ClusterDomain: "cluster.local"},
+		Detect: Detect{
+			// Agent namespaces of the common installs: manifests, the Calico
+			// operator, the flannel manifest and the Cilium chart
+			Namespaces: []string{"kube-system", "calico-system", "kube-flannel", "cilium"},
+			CNIConfDir: "/etc/cni/net.d",
+			ProcDir:    "/proc",
+			SysDir:     "/sys",
+			OSRelease:  "/etc/os-release",
+		},
+		Info: InfoSource{
+			Namespace:    "default",
+			OSConfigMap:  "os-info",
+			K8sConfigMap: "k8s-info",
+			CNIConfigMap: "cni-info",
+		},
+		Status: Status{Events: true, ConfigMap: "cni-benchmark-status"},
+		Queue:  Queue{Slots: 1, PollInterval: 2 * time.Second, TTL: time.Minute},
+		Supervisor: Supervisor{
+			Servers:        1,
+			InitialBackoff: time.Second,
+			MaxBackoff:     30 * time.Second,
+		},
+		Tracing: Tracing{SampleRatio: 1},
+		Log:     Log{Level: "info", Format: logging.FormatConsole},
 	}
 
 	// Automatically read environment variables
 	cfg.viper.AutomaticEnv()
 
-	// Unmarshal the configuration into the struct
+	if file := cmp.Or(src.File, os.Getenv("CONFIG_FILE")); len(file) > 0 {
+		cfg.viper.SetConfigFile(file)
+		if err = cfg.viper.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+	}
+
+	if src.Flags != nil {
+		// Only flags set on the command line, defaults of the others would
+		// override the environment
+		var bindErr error
+		src.Flags.Visit(func(flag *pflag.Flag) {
+			key := strings.ReplaceAll(flag.Name, "-", "_")
+			if keys := flag.Annotations[KeyAnnotation]; len(keys) > 0 {
+				key = keys[0]
+			}
+			if len(key) == 0 || flag.Name == FileFlag {
+				return
+			}
+			bindErr = cmp.Or(bindErr, cfg.viper.BindPFlag(key, flag))
+		})
+		if bindErr != nil {
+			return nil, fmt.Errorf("failed to bind flags: %w", bindErr)
+		}
+	}
+	for key, value := range src.Overrides {
+		cfg.viper.Set(key, value)
+	}
+	// Info values are read from the prefixed variables, like BENCH_OS_NAME
+	// for info.values.os_name
+	prefix := cfg.viper.GetString("info.key_prefix")
+	for _, key := range InfoKeys {
+		if err = cfg.viper.BindEnv("info.values."+strings.ToLower(key), prefix+key); err != nil {
+			return nil, fmt.Errorf("failed to bind info values: %w", err)
+		}
+	}
+
+	// Unmarshal the configuration into the struct, keep going on errors to
+	// report them along with the invalid settings below
+	var errs []error
+	if cfg.DatabaseCredential, err = readCredential(cfg, "database_url"); err != nil {
+		errs = append(errs, err)
+	}
 	if err = cfg.viper.Unmarshal(cfg, viper.DecodeHook(
 		mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToSliceHookFunc(","),
 			decodeArgs,
+			decodeLabels,
 			decodeMode,
+			decodeTargetType,
 			decodeServer,
+			decodeIPFamily,
 			decodeURL,
 			decodeDatabaseDialector,
 		),
-	)); err != nil {
-		return nil, fmt.Errorf("unable to unmarshal config into struct: %w", err)
+	), func(dc *mapstructure.DecoderConfig) { dc.ErrorUnused = true }); err != nil {
+		errs = append(errs, fmt.Errorf("unable to unmarshal config into struct: %w", err))
+	}
+	// The values map itself shadows its variables in Unmarshal, read every
+	// key on its own to keep the precedence
+	for _, key := range InfoKeys {
+		key = strings.ToLower(key)
+		if value := cfg.viper.GetString("info.values." + key); len(value) > 0 {
+			if cfg.Info.Values == nil {
+				cfg.Info.Values = map[string]string{}
+			}
+			cfg.Info.Values[key] = value
+		}
 	}
 
 	// Create a unique identifier for this instance
@@ -51,28 +182,353 @@ func Build() (cfg *Config, err error) {
 		cfg.Lease.ID = fmt.Sprintf("%s_%d", hostname, time.Now().Unix())
 	}
 
+	if cfg.DatabaseCredential != nil && len(cfg.DatabaseCredential.File) > 0 {
+		// Fail early on an unreadable file, it is read again on every use
+		if cfg.DatabaseDialector, err = cfg.Dialector(context.Background()); err != nil {
+			errs = append(errs, fmt.Errorf("invalid database URL file: %w", err))
+		}
+	}
+
+	if _, err = logging.New(cfg.Log.Level, cfg.Log.Format); err != nil {
+		errs = append(errs, fmt.Errorf("invalid log settings: %w", err))
+	}
+
+	errs = append(errs, cfg.Database.validate())
+
+	if cfg.Tracing.SampleRatio < 0 || cfg.Tracing.SampleRatio > 1 {
+		errs = append(errs, errors.New("tracing sample ratio must be between 0 and 1"))
+	}
+
+	if cfg.Standalone {
+		errs = append(errs, cfg.validateStandalone())
+		cfg.Lease.Lock = LockNone
+	}
+
+	errs = append(errs, cfg.Lease.validate())
+
+	if len(cfg.LabelsConfigMap) > 0 {
+		_, _, err = cfg.LabelsConfigMapRef()
+		errs = append(errs, err)
+	}
+
+	if len(cfg.Targets.Service) > 0 {
+		_, _, err = cfg.Targets.ServiceRef()
+		errs = append(errs, err)
+		if len(cfg.Targets.Types) == 0 {
+			cfg.Targets.Types = []TargetType{TargetPod, TargetClusterIP}
+		}
+	}
+
+	if len(cfg.Queue.ConfigMap) > 0 {
+		if cfg.Queue.Slots < 1 {
+			errs = append(errs, errors.New("queue must have at least one slot"))
+		}
+		if cfg.Queue.PollInterval <= 0 || cfg.Queue.TTL <= cfg.Queue.PollInterval {
+			errs = append(errs, errors.New("queue poll interval must be positive and shorter than its TTL"))
+		}
+	}
+
+	errs = append(errs, cfg.normalizeServer())
+
 	// Set some arguments and check mandatory configuration fields are set
+	if cfg.Args == nil {
+		cfg.Args = Args{}
+	}
 	cfg.Args["--port"] = strconv.Itoa(int(cfg.Port))
+	cfg.Args.setIPFamily(cfg.IPFamily)
 	switch cfg.Mode {
 	case ModeClient:
-		if cfg.DatabaseDialector == nil {
-			return nil, errors.New("database connection string is not set")
+		if cfg.DatabaseDialector == nil && cfg.DatabaseCredential == nil {
+			errs = append(errs, errors.New("database connection string is not set"))
 		}
 		cfg.Args["--client"] = string(cfg.Server)
 		cfg.Args["--time"] = strconv.Itoa(int(cfg.Duration))
-		cfg.Args["--json"] = ""
+		if len(cfg.Metrics.Address) > 0 {
+			// Stream intervals as they happen for the live throughput gauge,
+			// the run falls back to --json on iperf3 before 3.17
+			cfg.Args["--json-stream"] = ""
+		} else {
+			cfg.Args["--json"] = ""
+		}
 	case ModeServer:
 		cfg.Args["--server"] = ""
+		if cfg.Supervisor.Servers < 1 || int(cfg.Port)+cfg.Supervisor.Servers-1 > math.MaxUint16 {
+			errs = append(errs, errors.New("number of servers must be positive and fit the port range"))
+		}
+		if cfg.Supervisor.InitialBackoff <= 0 || cfg.Supervisor.MaxBackoff < cfg.Supervisor.InitialBackoff {
+			errs = append(errs, errors.New("supervisor backoff must be positive with the maximum above the initial one"))
+		}
+	case ModeDNS:
+		if cfg.DatabaseDialector == nil && cfg.DatabaseCredential == nil {
+			errs = append(errs, errors.New("database connection string is not set"))
+		}
+		if len(cfg.DNS.Names) == 0 {
+			errs = append(errs, errors.New("at least one DNS name must be set in dns mode"))
+		}
+		if cfg.DNS.Interval <= 0 || cfg.DNS.Timeout <= 0 {
+			errs = append(errs, errors.New("DNS interval and timeout must be positive"))
+		}
 	}
 
-	// Prepare full command to run
+	if err = errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	cfg.buildCommand()
+	return
+}
+
+// normalizeServer strips brackets and the port from a literal server address,
+// the port overrides the configured one, and checks the address fits the
+// selected IP family
+func (cfg *Config) normalizeServer() error {
+	if len(cfg.Server) == 0 {
+		return nil
+	}
+	kind, _, _, err := cfg.Server.Reference()
+	if err != nil || len(kind) > 0 {
+		return err
+	}
+	host, port, err := cfg.Server.SplitHostPort()
+	if err != nil {
+		return err
+	}
+	cfg.Server = Address(host)
+	if port > 0 {
+		cfg.Port = port
+	}
+	switch family := cfg.Server.Family(); {
+	case family == IPFamilyAny:
+		return nil
+	case cfg.IPFamily == IPFamilyDual:
+		return fmt.Errorf("dual-stack runs need a hostname or a server reference, got %s address %s", family, host)
+	case len(cfg.IPFamily) > 0 && cfg.IPFamily != family:
+		return fmt.Errorf("server %s is not an %s address", host, cfg.IPFamily)
+	}
+	return nil
+}
+
+// setIPFamily sets the iperf3 flag restricting it to the family, dual-stack
+// runs pick one family per run
+func (args Args) setIPFamily(family IPFamily) {
+	delete(args, "-4")
+	delete(args, "-6")
+	switch family {
+	case IPFamilyIPv4:
+		args["-4"] = ""
+	case IPFamilyIPv6:
+		args["-6"] = ""
+	}
+}
+
+// validateStandalone rejects settings which need the Kubernetes API
+func (cfg *Config) validateStandalone() error {
+	kind, _, _, err := cfg.Server.Reference()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, setting := range []struct {
+		name string
+		set  bool
+	}{
+		{"server reference", len(kind) > 0},
+		{"targets service", len(cfg.Targets.Service) > 0},
+		{"labels configmap", len(cfg.LabelsConfigMap) > 0},
+		{"queue configmap", len(cfg.Queue.ConfigMap) > 0},
+		{"database URL secret reference", cfg.DatabaseCredential != nil && cfg.DatabaseCredential.Secret != nil},
+	} {
+		if setting.set {
+			errs = append(errs, fmt.Errorf("%s needs Kubernetes and can't be used in standalone mode", setting.name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validate checks the lock backend and timings the same way the leader
+// election does, so misconfiguration fails early
+func (lease *Lease) validate() error {
+	lease.Lock = strings.ToLower(strings.TrimSpace(lease.Lock))
+	switch lease.Lock {
+	case LockNone:
+		return nil
+	case LockLeases:
+	default:
+		return fmt.Errorf("unknown lease lock %q, use %s or %s", lease.Lock, LockLeases, LockNone)
+	}
+	if lease.RetryPeriod <= 0 {
+		return errors.New("lease retry period must be positive")
+	}
+	if float64(lease.RenewDeadline) <= leaderelection.JitterFactor*float64(lease.RetryPeriod) {
+		return fmt.Errorf("lease renew deadline must be greater than %v times the retry period", leaderelection.JitterFactor)
+	}
+	if lease.Duration <= lease.RenewDeadline {
+		return errors.New("lease duration must be greater than the renew deadline")
+	}
+	return nil
+}
+
+// buildCommand prepares full command to run from the arguments
+func (cfg *Config) buildCommand() {
+	cfg.Command = cfg.Command[:1:1]
+	for _, key := range slices.Sorted(maps.Keys(cfg.Args)) {
+		cfg.Command = append(cfg.Command, strings.Trim(fmt.Sprintf("%s=%s", key, cfg.Args[key]), "="))
+	}
+}
+
+// WithTarget returns a copy of the client configuration pointed to another server
+func (cfg *Config) WithTarget(server Address, port uint16) *Config {
+	target := cfg.WithPort(port)
+	target.Server = server
+	target.Args["--client"] = string(server)
+	target.buildCommand()
+	return target
+}
+
+// WithPort returns a copy of the configuration connecting or listening on
+// another port
+func (cfg *Config) WithPort(port uint16) *Config {
+	target := *cfg
+	target.Port = port
+	target.Args = make(Args, len(cfg.Args))
 	for key, value := range cfg.Args {
-		cfg.Command = append(cfg.Command, strings.Trim(fmt.Sprintf("%s=%s", key, value), "="))
+		target.Args[key] = value
+	}
+	target.Args["--port"] = strconv.Itoa(int(port))
+	target.buildCommand()
+	return &target
+}
+
+// WithJSON returns a copy of the client configuration reporting with --json
+// instead of --json-stream, for iperf3 releases before 3.17
+func (cfg *Config) WithJSON() *Config {
+	target := cfg.WithPort(cfg.Port)
+	delete(target.Args, "--json-stream")
+	target.Args["--json"] = ""
+	target.buildCommand()
+	return target
+}
+
+// WithIPFamily returns a copy of the configuration restricted to a single IP
+// family, used to split dual-stack runs
+func (cfg *Config) WithIPFamily(family IPFamily) *Config {
+	target := cfg.WithPort(cfg.Port)
+	target.IPFamily = family
+	target.Args.setIPFamily(family)
+	target.buildCommand()
+	return target
+}
+
+// Reference splits a server reference like pod:namespace/selector or
+// service:namespace/name, kind is empty for a literal domain or IP
+func (a Address) Reference() (kind, namespace, value string, err error) {
+	kind, rest, found := strings.Cut(string(a), ":")
+	if !found || (kind != ReferencePod && kind != ReferenceService) {
+		return "", "", "", nil
+	}
+	namespace, value, found = strings.Cut(rest, "/")
+	if !found || len(namespace) == 0 || len(value) == 0 {
+		return "", "", "", fmt.Errorf("server reference must be %s:namespace/value, got %q", kind, a)
+	}
+	switch kind {
+	case ReferencePod:
+		if _, err = labels.Parse(value); err != nil {
+			return "", "", "", fmt.Errorf("invalid pod selector %q: %w", value, err)
+		}
+	case ReferenceService:
+		if strings.Contains(value, "/") {
+			return "", "", "", fmt.Errorf("invalid service name %q", value)
+		}
+	}
+	return
+}
+
+// hostnamePattern matches RFC 1123 hostnames including single labels and
+// fully qualified names with a trailing dot
+var hostnamePattern = regexp.MustCompile(
+	`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*\.?$`)
+
+// SplitHostPort splits a literal server address into the host and the port,
+// which is 0 when there is none. IPv6 may be bracketed and must be when the
+// port is given, e.g. [fd00::1]:5201.
+func (a Address) SplitHostPort() (host string, port uint16, err error) {
+	value := string(a)
+	switch {
+	case strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]"):
+		host = value[1 : len(value)-1]
+		if addr, err := netip.ParseAddr(host); err != nil || !addr.Is6() {
+			return "", 0, fmt.Errorf("only IPv6 addresses are bracketed, got %q", value)
+		}
+		return host, 0, nil
+	case strings.Count(value, ":") > 1 && !strings.HasPrefix(value, "["):
+		// Bare IPv6 without a port
+		host = value
+	case strings.Contains(value, ":"):
+		var rawPort string
+		if host, rawPort, err = net.SplitHostPort(value); err != nil {
+			return "", 0, fmt.Errorf("invalid server address %q: %w", value, err)
+		}
+		parsed, err := strconv.ParseUint(rawPort, 10, 16)
+		if err != nil || parsed == 0 {
+			return "", 0, fmt.Errorf("invalid port in server address %q", value)
+		}
+		port = uint16(parsed)
+		if addr, err := netip.ParseAddr(host); strings.HasPrefix(value, "[") && (err != nil || !addr.Is6()) {
+			return "", 0, fmt.Errorf("only IPv6 addresses are bracketed, got %q", value)
+		}
+	default:
+		host = value
 	}
+	if _, err = netip.ParseAddr(host); err == nil {
+		return host, port, nil
+	}
+	if len(host) > 254 || !hostnamePattern.MatchString(host) {
+		return "", 0, fmt.Errorf("server is neither hostname nor IP: %s", value)
+	}
+	return host, port, nil
+}
+
+// Family returns the IP family of a literal IP address, empty for hostnames
+func (a Address) Family() IPFamily {
+	addr, err := netip.ParseAddr(string(a))
+	switch {
+	case err != nil:
+		return IPFamilyAny
+	case addr.Unmap().Is4():
+		return IPFamilyIPv4
+	default:
+		return IPFamilyIPv6
+	}
+}
+
+// LabelsConfigMapRef splits the labels ConfigMap reference into namespace and name
+func (cfg *Config) LabelsConfigMapRef() (namespace, name string, err error) {
+	return splitNamespacedName("labels configmap", cfg.LabelsConfigMap)
+}
+
+// ServiceRef splits the server Service reference into namespace and name
+func (t *Targets) ServiceRef() (namespace, name string, err error) {
+	return splitNamespacedName("targets service", t.Service)
+}
 
+func splitNamespacedName(what, ref string) (namespace, name string, err error) {
+	namespace, name, ok := strings.Cut(ref, "/")
+	if !ok || len(namespace) == 0 || len(name) == 0 || strings.Contains(name, "/") {
+		return "", "", fmt.Errorf("%s must be namespace/name, got %q", what, ref)
+	}
 	return
 }
 
+// ConfigMapKey replaces characters ConfigMap keys don't allow
+func ConfigMapKey(key string) string {
+	key = strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || r == '.' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			return r
+		}
+		return '_'
+	}, strings.Trim(key, "."))
+	return cmp.Or(key, "default")
+}
+
 type envReplacer struct{}
 
 func (r *envReplacer) Replace(s string) string {
@@ -1,12 +1,27 @@
 package config
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/spf13/pflag"
 	"gorm.io/driver/sqlite"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 func TestConfig(t *testing.T) {
@@ -29,6 +44,9 @@ var _ = Describe("Configuration", func() {
 		"ARGS":            "--help: ''\nkey: value",
 		"TEST_CASE":       "01-p2sh-tcp",
 		"ALIGN_TIME":      "false",
+		"EXTRA_LABELS":    "encryption=wireguard,mtu=9000",
+		"INFO_CONFIGMAP":  "bench-info",
+		"INFO_KEY_PREFIX": "BENCH_",
 	}
 
 	BeforeEach(func() {
@@ -55,6 +73,8 @@ var _ = Describe("Configuration", func() {
 		Expect(cfg.Lease.Namespace).To(Equal("test"))
 		Expect(cfg.Lease.Name).To(Equal("test"))
 		Expect(cfg.Lease.ID).To(Equal("test"))
+		Expect(cfg.Lease.Lock).To(Equal(LockLeases))
+		Expect(cfg.Lease.Duration).To(Equal(20 * time.Second))
 		Expect(cfg.DatabaseDialector).ToNot(BeNil())
 		Expect(cfg.DatabaseDialector).To(Equal(sqlite.Open("file::memory:?cache=shared")))
 		Expect(cfg.Args).To(Equal(Args{
@@ -69,5 +89,619 @@ var _ = Describe("Configuration", func() {
 			"iperf3", "--json", "--help", "key=value", "--port=80", "--client=example.com", "--time=1234",
 		))
 		Expect(cfg.TestCase).To(Equal("01-p2sh-tcp"))
+		Expect(cfg.ExtraLabels).To(Equal(LabelMap{"encryption": "wireguard", "mtu": "9000"}))
+		Expect(cfg.DNS.Interval).To(Equal(100 * time.Millisecond))
+		Expect(cfg.DNS.Timeout).To(Equal(2 * time.Second))
+		Expect(cfg.Info).To(Equal(InfoSource{
+			Namespace:    "default",
+			OSConfigMap:  "os-info",
+			K8sConfigMap: "k8s-info",
+			CNIConfigMap: "cni-info",
+			ConfigMap:    "bench-info",
+			KeyPrefix:    "BENCH_",
+		}))
+		Expect(cfg.Status).To(Equal(Status{Events: true, ConfigMap: "cni-benchmark-status"}))
+	})
+
+	Context("Standalone", func() {
+		AfterEach(func() {
+			for _, name := range []string{"STANDALONE", "SERVER", "QUEUE_CONFIGMAP"} {
+				Expect(os.Unsetenv(name)).To(Succeed())
+			}
+		})
+
+		It("should disable the leader election", func() {
+			Expect(os.Setenv("STANDALONE", "true")).To(Succeed())
+			cfg, err = Build()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfg.Standalone).To(BeTrue())
+			Expect(cfg.Lease.Lock).To(Equal(LockNone))
+		})
+
+		It("should reject settings which need Kubernetes", func() {
+			Expect(os.Setenv("STANDALONE", "true")).To(Succeed())
+			Expect(os.Setenv("SERVER", "pod:bench/app=iperf3")).To(Succeed())
+			_, err = Build()
+			Expect(err).To(MatchError(ContainSubstring("standalone")))
+
+			Expect(os.Setenv("SERVER", "10.0.0.2")).To(Succeed())
+			Expect(os.Setenv("QUEUE_CONFIGMAP", "cni-benchmark-queue")).To(Succeed())
+			_, err = Build()
+			Expect(err).To(MatchError(ContainSubstring("standalone")))
+		})
+	})
+
+	Context("Lease", func() {
+		names := []string{"LEASE_LOCK", "LEASE_DURATION", "LEASE_RENEW_DEADLINE", "LEASE_RETRY_PERIOD"}
+		AfterEach(func() {
+			for _, name := range names {
+				Expect(os.Unsetenv(name)).To(Succeed())
+			}
+		})
+
+		It("should parse leader election settings", func() {
+			for i, value := range []string{"None", "2m", "1m", "5s"} {
+				Expect(os.Setenv(names[i], value)).To(Succeed())
+			}
+			cfg, err = Build()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfg.Lease.Lock).To(Equal(LockNone))
+			Expect(cfg.Lease.Duration).To(Equal(2 * time.Minute))
+			Expect(cfg.Lease.RenewDeadline).To(Equal(time.Minute))
+			Expect(cfg.Lease.RetryPeriod).To(Equal(5 * time.Second))
+		})
+
+		DescribeTable("should reject invalid settings", func(lock, duration, renewDeadline, retryPeriod string) {
+			for i, value := range []string{lock, duration, renewDeadline, retryPeriod} {
+				Expect(os.Setenv(names[i], value)).To(Succeed())
+			}
+			_, err = Build()
+			Expect(err).To(HaveOccurred())
+		},
+			Entry("unknown lock", "configmaps", "20s", "10s", "1s"),
+			Entry("duration below renew deadline", "leases", "10s", "20s", "1s"),
+			Entry("renew deadline below jittered retry period", "leases", "20s", "10s", "9s"),
+			Entry("non-positive retry period", "leases", "20s", "10s", "0s"),
+		)
+	})
+
+	It("should stream iperf3 output when metrics are enabled", func() {
+		Expect(os.Setenv("METRICS_ADDRESS", ":9090")).To(Succeed())
+		DeferCleanup(os.Unsetenv, "METRICS_ADDRESS")
+		cfg, err = Build()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cfg.Args).To(HaveKey("--json-stream"))
+		Expect(cfg.Args).ToNot(HaveKey("--json"))
+	})
+
+	Context("Config file", func() {
+		var file string
+
+		BeforeEach(func() {
+			file = filepath.Join(GinkgoT().TempDir(), "config.yaml")
+			Expect(os.WriteFile(file, []byte(`
+test_case: from-file
+duration: 30
+lease:
+  namespace: from-file
+  retry_period: 2s
+args:
+  --bitrate: 1G
+  --parallel: 4
+  --reverse:
+dns:
+  names: [a.example.com, b.example.com]
+`), 0o600)).To(Succeed())
+		})
+
+		It("should take the file over defaults, env over the file and flags over env", func() {
+			flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+			flags.String("config", "", "")
+			flags.String("test-case", "", "")
+			flags.String("lease.namespace", "", "")
+			Expect(flags.Parse([]string{"--config", file, "--test-case", "from-flag"})).To(Succeed())
+			cfg, err = BuildFrom(Source{File: file, Flags: flags})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfg.TestCase).To(Equal("from-flag"))
+			Expect(cfg.Duration).To(Equal(uint16(1234)))
+			Expect(cfg.Lease.Namespace).To(Equal("test"))
+			Expect(cfg.Lease.RetryPeriod).To(Equal(2 * time.Second))
+			Expect(cfg.Lease.Duration).To(Equal(20 * time.Second))
+			Expect(cfg.DNS.Names).To(Equal([]string{"a.example.com", "b.example.com"}))
+		})
+
+		It("should bind flags by annotation and apply overrides", func() {
+			flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+			flags.String("log-level", "", "")
+			flags.Bool("dry-run", false, "")
+			Expect(flags.SetAnnotation("log-level", KeyAnnotation, []string{"log.level"})).To(Succeed())
+			Expect(flags.SetAnnotation("dry-run", KeyAnnotation, []string{""})).To(Succeed())
+			Expect(flags.Parse([]string{"--log-level", "debug", "--dry-run"})).To(Succeed())
+			cfg, err = BuildFrom(Source{Flags: flags, Overrides: map[string]any{"mode": "server"}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfg.Log.Level).To(Equal("debug"))
+			Expect(cfg.Mode).To(Equal(ModeServer))
+			Expect(cfg.Command).To(Equal([]string{"iperf3", "--help", "--port=80", "--server", "key=value"}))
+		})
+
+		It("should read info values from the file and the prefixed variables", func() {
+			Expect(os.WriteFile(file, []byte("info:\n  values:\n    os_name: Debian\n    k8s_provider: from-file\n"), 0o600)).To(Succeed())
+			Expect(os.Setenv("BENCH_K8S_PROVIDER", "bare-metal")).To(Succeed())
+			DeferCleanup(os.Unsetenv, "BENCH_K8S_PROVIDER")
+			cfg, err = BuildFrom(Source{File: file})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfg.Info.Values).To(Equal(map[string]string{"os_name": "Debian", "k8s_provider": "bare-metal"}))
+		})
+
+		It("should read args as a map", func() {
+			Expect(os.Unsetenv("ARGS")).To(Succeed())
+			cfg, err = BuildFrom(Source{File: file})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfg.Args).To(HaveKeyWithValue("--bitrate", "1G"))
+			Expect(cfg.Args).To(HaveKeyWithValue("--parallel", "4"))
+			Expect(cfg.Args).To(HaveKeyWithValue("--reverse", ""))
+		})
+
+		It("should report all errors at once", func() {
+			Expect(os.WriteFile(file, []byte("lease:\n  bogus: 1\n  retry_period: 0s\nlog:\n  format: xml\n"), 0o600)).To(Succeed())
+			Expect(os.Setenv("QUEUE_CONFIGMAP", "cni-benchmark-queue")).To(Succeed())
+			DeferCleanup(os.Unsetenv, "QUEUE_CONFIGMAP")
+			Expect(os.Setenv("QUEUE_SLOTS", "0")).To(Succeed())
+			DeferCleanup(os.Unsetenv, "QUEUE_SLOTS")
+			_, err = BuildFrom(Source{File: file})
+			Expect(err).To(MatchError(And(
+				ContainSubstring("invalid keys: bogus"),
+				ContainSubstring("unknown log format"),
+				ContainSubstring("lease retry period must be positive"),
+				ContainSubstring("queue must have at least one slot"),
+			)))
+		})
+
+		It("should fail on a missing file", func() {
+			_, err = BuildFrom(Source{File: filepath.Join(GinkgoT().TempDir(), "missing.yaml")})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should match the published schema", func() {
+			schema, err := Schema()
+			Expect(err).ToNot(HaveOccurred())
+			published, err := os.ReadFile("../../config.schema.json")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(published)).To(Equal(string(schema)+"\n"), "regenerate it with task schema")
+		})
+	})
+
+	Context("Credentials", func() {
+		AfterEach(func() {
+			Expect(os.Unsetenv("DATABASE_URL_FILE")).To(Succeed())
+		})
+
+		It("should read the database URL from a file on every use", func() {
+			file := filepath.Join(GinkgoT().TempDir(), "database-url")
+			Expect(os.WriteFile(file, []byte("sqlite://first.db\n"), 0o600)).To(Succeed())
+			Expect(os.Unsetenv("DATABASE_URL")).To(Succeed())
+			Expect(os.Setenv("DATABASE_URL_FILE", file)).To(Succeed())
+			cfg, err = Build()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfg.DatabaseDialector).To(Equal(sqlite.Open("file:first.db")))
+
+			Expect(os.WriteFile(file, []byte("sqlite://second.db"), 0o600)).To(Succeed())
+			dialector, err := cfg.Dialector(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dialector).To(Equal(sqlite.Open("file:second.db")))
+		})
+
+		It("should reject both a database URL and a file", func() {
+			Expect(os.Setenv("DATABASE_URL_FILE", "/run/secrets/database-url")).To(Succeed())
+			_, err = Build()
+			Expect(err).To(MatchError(ContainSubstring("mutually exclusive")))
+		})
+
+		It("should resolve Secret references through the client", func() {
+			Expect(os.Setenv("DATABASE_URL", "secret://bench/database/url")).To(Succeed())
+			cfg, err = Build()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfg.DatabaseDialector).To(BeNil())
+			Expect(cfg.DatabaseCredential.Secret).To(Equal(&SecretRef{Namespace: "bench", Name: "database", Key: "url"}))
+			Expect(fmt.Sprintf("%+v", cfg.MarshalLog())).To(ContainSubstring("DatabaseURL:secret://bench/database/url"))
+
+			_, err = cfg.Dialector(context.Background())
+			Expect(err).To(MatchError(ContainSubstring("needs a Kubernetes client")))
+
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "bench", Name: "database"},
+				Data:       map[string][]byte{"url": []byte("postgres://bench:first@db/cni")},
+			}
+			cfg.K8sClient = fake.NewClientset(secret)
+			dialector, err := cfg.Dialector(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(DatabaseDSN(dialector)).To(Equal("postgres://bench:first@db/cni"))
+
+			secret.Data["url"] = []byte("postgres://bench:second@db/cni")
+			_, err = cfg.K8sClient.CoreV1().Secrets("bench").Update(context.Background(), secret, metav1.UpdateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			dialector, err = cfg.Dialector(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(DatabaseDSN(dialector)).To(Equal("postgres://bench:second@db/cni"))
+		})
+
+		It("should reject malformed Secret references and ones in standalone mode", func() {
+			Expect(os.Setenv("DATABASE_URL", "secret://bench/database")).To(Succeed())
+			_, err = Build()
+			Expect(err).To(HaveOccurred())
+
+			Expect(os.Setenv("DATABASE_URL", "secret://bench/database/url")).To(Succeed())
+			Expect(os.Setenv("STANDALONE", "true")).To(Succeed())
+			DeferCleanup(os.Unsetenv, "STANDALONE")
+			_, err = Build()
+			Expect(err).To(MatchError(ContainSubstring("can't be used in standalone mode")))
+		})
+	})
+
+	Context("Database connection", func() {
+		databaseEnv := []string{
+			"DATABASE_TLS_MODE", "DATABASE_TLS_CA", "DATABASE_TLS_CERT", "DATABASE_CONNECT_TIMEOUT",
+			"DATABASE_MAX_OPEN_CONNS", "DATABASE_TABLE_PREFIX", "DATABASE_URL_FILE",
+			"DATABASE_TIMESCALE_ENABLED", "DATABASE_TIMESCALE_RETENTION", "DATABASE_TIMESCALE_CHUNK_INTERVAL",
+		}
+		AfterEach(func() {
+			for _, name := range databaseEnv {
+				Expect(os.Unsetenv(name)).To(Succeed())
+			}
+		})
+
+		It("should parse connection settings", func() {
+			Expect(os.Setenv("DATABASE_TLS_MODE", "verify-full")).To(Succeed())
+			Expect(os.Setenv("DATABASE_CONNECT_TIMEOUT", "5s")).To(Succeed())
+			Expect(os.Setenv("DATABASE_MAX_OPEN_CONNS", "4")).To(Succeed())
+			Expect(os.Setenv("DATABASE_TABLE_PREFIX", "bench_")).To(Succeed())
+			cfg, err = Build()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfg.Database.TLS.Mode).To(Equal(TLSVerifyFull))
+			Expect(cfg.Database.ConnectTimeout).To(Equal(5 * time.Second))
+			Expect(cfg.Database.MaxOpenConns).To(Equal(4))
+			Expect(cfg.Database.TablePrefix).To(Equal("bench_"))
+		})
+
+		It("should reject invalid settings", func() {
+			Expect(os.Setenv("DATABASE_TLS_MODE", "prefer")).To(Succeed())
+			Expect(os.Setenv("DATABASE_TLS_CERT", "/tls/client.crt")).To(Succeed())
+			Expect(os.Setenv("DATABASE_MAX_OPEN_CONNS", "-1")).To(Succeed())
+			_, err = Build()
+			Expect(err).To(MatchError(ContainSubstring("unknown database TLS mode")))
+			Expect(err).To(MatchError(ContainSubstring("certificate and key must be set together")))
+			Expect(err).To(MatchError(ContainSubstring("pool sizes must not be negative")))
+		})
+
+		It("should parse TimescaleDB settings", func() {
+			Expect(os.Setenv("DATABASE_TIMESCALE_ENABLED", "true")).To(Succeed())
+			Expect(os.Setenv("DATABASE_TIMESCALE_RETENTION", "720h")).To(Succeed())
+			Expect(os.Setenv("DATABASE_TIMESCALE_CHUNK_INTERVAL", "24h")).To(Succeed())
+			cfg, err = Build()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfg.Database.Timescale).To(Equal(Timescale{Enabled: true, ChunkInterval: 24 * time.Hour, Retention: 720 * time.Hour}))
+
+			Expect(os.Setenv("DATABASE_TIMESCALE_RETENTION", "1h")).To(Succeed())
+			Expect(os.Setenv("DATABASE_TIMESCALE_CHUNK_INTERVAL", "-1h")).To(Succeed())
+			_, err = Build()
+			Expect(err).To(MatchError(ContainSubstring("timescale retention must be at least")))
+			Expect(err).To(MatchError(ContainSubstring("timescale intervals must not be negative")))
+		})
+
+		It("should open prefixed tables with the pool settings", func() {
+			cfg.DatabaseDialector = sqlite.Open("file:" + filepath.Join(GinkgoT().TempDir(), "prefix.db"))
+			cfg.Database.TablePrefix = "bench_"
+			cfg.Database.MaxOpenConns = 3
+			db, err := cfg.Open(cfg.DatabaseDialector)
+			Expect(err).ToNot(HaveOccurred())
+			type Sample struct{ ID uint }
+			Expect(db.AutoMigrate(&Sample{})).To(Succeed())
+			Expect(db.Migrator().HasTable("bench_samples")).To(BeTrue())
+			pool, err := db.DB()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pool.Stats().MaxOpenConnections).To(Equal(3))
+		})
+
+		It("should reuse the connection until the URL changes", func() {
+			dir := GinkgoT().TempDir()
+			file := filepath.Join(dir, "database-url")
+			Expect(os.WriteFile(file, []byte("sqlite://"+filepath.Join(dir, "first.db")), 0o600)).To(Succeed())
+			Expect(os.Unsetenv("DATABASE_URL")).To(Succeed())
+			Expect(os.Setenv("DATABASE_URL_FILE", file)).To(Succeed())
+			cfg, err = Build()
+			Expect(err).ToNot(HaveOccurred())
+
+			conn := cfg.Connect()
+			DeferCleanup(conn.Close)
+			first, err := conn.DB(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			again, err := conn.DB(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(again).To(BeIdenticalTo(first))
+
+			Expect(os.WriteFile(file, []byte("sqlite://"+filepath.Join(dir, "second.db")), 0o600)).To(Succeed())
+			rotated, err := conn.DB(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rotated).ToNot(BeIdenticalTo(first))
+		})
+
+		It("should build TLS settings for each mode", func() {
+			ca := writeCA(GinkgoT().TempDir())
+			settings := DatabaseTLS{CA: ca}
+			config, err := settings.Config("db.example.com")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.RootCAs).ToNot(BeNil())
+			Expect(config.ServerName).To(Equal("db.example.com"))
+			Expect(config.InsecureSkipVerify).To(BeFalse())
+
+			settings.Mode = TLSVerifyCA
+			config, err = settings.Config("db.example.com")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.InsecureSkipVerify).To(BeTrue())
+			Expect(config.VerifyPeerCertificate).ToNot(BeNil())
+
+			settings = DatabaseTLS{Mode: TLSDisable}
+			Expect(settings.Config("db.example.com")).To(BeNil())
+
+			settings = DatabaseTLS{CA: filepath.Join(GinkgoT().TempDir(), "missing.pem")}
+			_, err = settings.Config("db.example.com")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should pass TLS settings to the drivers", func() {
+			cfg.Database.TLS = DatabaseTLS{CA: writeCA(GinkgoT().TempDir())}
+			for _, dsn := range []string{
+				"postgres://u:p@db:5432/d", "mysql://u:p@db:3306/d", "sqlserver://u:p@db:1433?database=d", "clickhouse://u:p@db:9000/d",
+			} {
+				dialector, err := OpenDialector(dsn)
+				Expect(err).ToNot(HaveOccurred())
+				configured, conn, err := cfg.Database.connector(dialector)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(conn).ToNot(BeNil())
+				Expect(configured.Name()).To(Equal(dialector.Name()))
+				Expect(DatabaseDSN(configured)).To(Equal(DatabaseDSN(dialector)))
+				Expect(conn.Close()).To(Succeed())
+			}
+			_, _, err = cfg.Database.connector(sqlite.Open("file::memory:"))
+			Expect(err).To(MatchError(ContainSubstring("not supported by sqlite")))
+		})
+	})
+
+	Context("Logging", func() {
+		AfterEach(func() {
+			for _, name := range []string{"LOG_LEVEL", "LOG_FORMAT"} {
+				Expect(os.Unsetenv(name)).To(Succeed())
+			}
+		})
+
+		It("should parse log settings", func() {
+			Expect(cfg.Log).To(Equal(Log{Level: "info", Format: "console"}))
+			Expect(os.Setenv("LOG_LEVEL", "debug")).To(Succeed())
+			Expect(os.Setenv("LOG_FORMAT", "json")).To(Succeed())
+			cfg, err = Build()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfg.Log).To(Equal(Log{Level: "debug", Format: "json"}))
+		})
+
+		It("should reject an unknown log format", func() {
+			Expect(os.Setenv("LOG_FORMAT", "xml")).To(Succeed())
+			_, err = Build()
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should redact credentials when logged", func() {
+			Expect(os.Setenv("DATABASE_URL", "postgres://bench:s3cr3t@db:5432/cni")).To(Succeed())
+			cfg, err = Build()
+			Expect(err).ToNot(HaveOccurred())
+			cfg.Args["--password"] = "s3cr3t"
+			cfg.buildCommand()
+			logged := fmt.Sprintf("%+v", cfg.MarshalLog())
+			Expect(logged).ToNot(ContainSubstring("s3cr3t"))
+			Expect(logged).To(ContainSubstring("DatabaseURL:postgres://bench:xxxxx@db:5432/cni"))
+			Expect(logged).To(ContainSubstring("--password=xxxxx"))
+			Expect(cfg.Args).To(HaveKeyWithValue("--password", "s3cr3t"))
+		})
+	})
+
+	Context("Supervisor", func() {
+		AfterEach(func() {
+			for _, name := range []string{"MODE", "SUPERVISOR_SERVERS"} {
+				Expect(os.Unsetenv(name)).To(Succeed())
+			}
+		})
+
+		It("should parse the server pool", func() {
+			Expect(os.Setenv("MODE", "server")).To(Succeed())
+			Expect(os.Setenv("SUPERVISOR_SERVERS", "4")).To(Succeed())
+			cfg, err = Build()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfg.Supervisor).To(Equal(Supervisor{
+				Servers: 4, InitialBackoff: time.Second, MaxBackoff: 30 * time.Second,
+			}))
+			Expect(cfg.WithPort(5204).Command).To(ContainElement("--port=5204"))
+		})
+
+		It("should reject a pool exceeding the port range", func() {
+			Expect(os.Setenv("MODE", "server")).To(Succeed())
+			Expect(os.Setenv("SUPERVISOR_SERVERS", "70000")).To(Succeed())
+			_, err = Build()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Queue", func() {
+		AfterEach(func() {
+			for _, name := range []string{"QUEUE_CONFIGMAP", "QUEUE_SLOTS", "QUEUE_TTL"} {
+				Expect(os.Unsetenv(name)).To(Succeed())
+			}
+		})
+
+		It("should parse the queue settings", func() {
+			Expect(os.Setenv("QUEUE_CONFIGMAP", "cni-benchmark-queue")).To(Succeed())
+			Expect(os.Setenv("QUEUE_SLOTS", "2")).To(Succeed())
+			cfg, err = Build()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfg.Queue).To(Equal(Queue{
+				ConfigMap: "cni-benchmark-queue", Slots: 2, PollInterval: 2 * time.Second, TTL: time.Minute,
+			}))
+		})
+
+		It("should reject a TTL shorter than the poll interval", func() {
+			Expect(os.Setenv("QUEUE_CONFIGMAP", "cni-benchmark-queue")).To(Succeed())
+			Expect(os.Setenv("QUEUE_TTL", "1s")).To(Succeed())
+			_, err = Build()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Server address", func() {
+		build := func(server, family string) (*Config, error) {
+			return BuildFrom(Source{Overrides: map[string]any{"server": server, "ip_family": family}})
+		}
+
+		DescribeTable("should parse literal addresses", func(server, host string, port int) {
+			cfg, err = build(server, "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfg.Server).To(Equal(Address(host)))
+			Expect(cfg.Port).To(Equal(uint16(port)))
+			Expect(cfg.Command).To(ContainElement("--client=" + host))
+		},
+			Entry("IPv4", "10.0.0.1", "10.0.0.1", 80),
+			Entry("IPv4 with a port", "10.0.0.1:5201", "10.0.0.1", 5201),
+			Entry("bare IPv6", "fd00::1", "fd00::1", 80),
+			Entry("bracketed IPv6", "[fd00::1]", "fd00::1", 80),
+			Entry("bracketed IPv6 with a port", "[fd00::1]:5201", "fd00::1", 5201),
+			Entry("hostname with a port", "iperf3-server:5201", "iperf3-server", 5201),
+			Entry("single label with digits", "server01", "server01", 80),
+			Entry("FQDN with a trailing dot", "server.bench.svc.cluster.local.", "server.bench.svc.cluster.local.", 80),
+		)
+
+		DescribeTable("should reject invalid addresses", func(server string) {
+			_, err = build(server, "")
+			Expect(err).To(HaveOccurred())
+		},
+			Entry("bracketed IPv4", "[10.0.0.1]:5201"),
+			Entry("port out of range", "server:70000"),
+			Entry("zero port", "server:0"),
+			Entry("underscore", "iperf3_server"),
+			Entry("label starting with a dash", "-server"),
+			Entry("empty label", "server..local"),
+		)
+
+		It("should pass the IP family to iperf3", func() {
+			cfg, err = build("example.com", "6")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfg.IPFamily).To(Equal(IPFamilyIPv6))
+			Expect(cfg.Command).To(ContainElement("-6"))
+
+			ipv4 := cfg.WithIPFamily(IPFamilyIPv4)
+			Expect(ipv4.Command).To(ContainElement("-4"))
+			Expect(ipv4.Command).ToNot(ContainElement("-6"))
+			Expect(cfg.Command).To(ContainElement("-6"))
+		})
+
+		It("should leave the family to each run in dual-stack mode", func() {
+			cfg, err = build("example.com", "dual")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfg.Command).ToNot(ContainElements("-4", "-6"))
+		})
+
+		It("should reject addresses of another family", func() {
+			_, err = build("10.0.0.1", "ipv6")
+			Expect(err).To(HaveOccurred())
+			_, err = build("fd00::1", "dual")
+			Expect(err).To(HaveOccurred())
+			_, err = build("example.com", "ipv5")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Targets", func() {
+		AfterEach(func() {
+			Expect(os.Unsetenv("TARGETS_SERVICE")).To(Succeed())
+			Expect(os.Unsetenv("TARGETS_TYPES")).To(Succeed())
+		})
+
+		It("should parse target types", func() {
+			Expect(os.Setenv("TARGETS_SERVICE", "bench/server")).To(Succeed())
+			Expect(os.Setenv("TARGETS_TYPES", "pod,Cluster-IP,node-port,headless")).To(Succeed())
+			cfg, err = Build()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfg.Targets.Types).To(Equal([]TargetType{TargetPod, TargetClusterIP, TargetNodePort, TargetHeadless}))
+			Expect(cfg.Targets.ClusterDomain).To(Equal("cluster.local"))
+		})
+
+		It("should reject malformed service references", func() {
+			for _, service := range []string{"server", "/server", "bench/", "a/b/c"} {
+				Expect(os.Setenv("TARGETS_SERVICE", service)).To(Succeed())
+				_, err = Build()
+				Expect(err).To(HaveOccurred())
+			}
+		})
+
+		It("should point a copy of the config to another target", func() {
+			target := cfg.WithTarget("10.96.0.42", 5201)
+			Expect(target.Args["--client"]).To(Equal("10.96.0.42"))
+			Expect(target.Command).To(ContainElements("--client=10.96.0.42", "--port=5201"))
+			Expect(cfg.Args["--client"]).To(Equal("example.com"))
+			Expect(cfg.Command).To(ContainElement("--client=example.com"))
+		})
+	})
+
+	Context("DNS mode", func() {
+		dnsEnv := map[string]string{
+			"MODE":         "dns",
+			"DNS_NAMES":    "kubernetes.default.svc.cluster.local,example.com",
+			"DNS_SERVER":   "10.96.0.10",
+			"DNS_INTERVAL": "250ms",
+			"DNS_TIMEOUT":  "1s",
+		}
+
+		AfterEach(func() {
+			for name := range dnsEnv {
+				Expect(os.Unsetenv(name)).To(Succeed())
+			}
+		})
+
+		It("should parse DNS settings", func() {
+			for name, value := range dnsEnv {
+				Expect(os.Setenv(name, value)).To(Succeed())
+			}
+			cfg, err = Build()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfg.Mode).To(Equal(ModeDNS))
+			Expect(cfg.DNS).To(Equal(DNS{
+				Names:    []string{"kubernetes.default.svc.cluster.local", "example.com"},
+				Server:   "10.96.0.10",
+				Interval: 250 * time.Millisecond,
+				Timeout:  time.Second,
+			}))
+		})
+
+		It("should require names to resolve", func() {
+			Expect(os.Setenv("MODE", "dns")).To(Succeed())
+			_, err = Build()
+			Expect(err).To(HaveOccurred())
+		})
 	})
 })
+
+// writeCA writes a self-signed CA certificate and returns its path
+func writeCA(dir string) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "bench-ca"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).ToNot(HaveOccurred())
+	path := filepath.Join(dir, "ca.pem")
+	Expect(os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)).To(Succeed())
+	return path
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"gorm.io/gorm"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// secretScheme prefixes references to a key of a Kubernetes Secret
+const secretScheme = "secret://"
+
+// Credential is the database URL kept out of plain environment variables,
+// read from a file or a Kubernetes Secret. It is read again on every Resolve,
+// so credentials rotated during long runs are picked up.
+type Credential struct {
+	File   string
+	Secret *SecretRef
+}
+
+// SecretRef is a key of a Kubernetes Secret given as secret://namespace/name/key
+type SecretRef struct {
+	Namespace string
+	Name      string
+	Key       string
+}
+
+// ParseSecretRef parses a secret://namespace/name/key reference, ok is false
+// for other values
+func ParseSecretRef(value string) (ref *SecretRef, ok bool, err error) {
+	rest, ok := strings.CutPrefix(value, secretScheme)
+	if !ok {
+		return nil, false, nil
+	}
+	parts := strings.Split(rest, "/")
+	if len(parts) != 3 || len(parts[0]) == 0 || len(parts[1]) == 0 || len(parts[2]) == 0 {
+		return nil, true, fmt.Errorf("secret reference must be %snamespace/name/key, got %q", secretScheme, value)
+	}
+	return &SecretRef{Namespace: parts[0], Name: parts[1], Key: parts[2]}, true, nil
+}
+
+func (r *SecretRef) String() string {
+	return secretScheme + r.Namespace + "/" + r.Name + "/" + r.Key
+}
+
+// Resolve reads the current value of the credential, surrounding whitespace
+// like a trailing newline of a file is dropped
+func (c *Credential) Resolve(ctx context.Context, client kubernetes.Interface) (string, error) {
+	switch {
+	case c.Secret != nil:
+		if client == nil {
+			return "", fmt.Errorf("%s needs a Kubernetes client", c.Secret)
+		}
+		secret, err := client.CoreV1().Secrets(c.Secret.Namespace).Get(ctx, c.Secret.Name, metav1.GetOptions{})
+		if err != nil {
+			return "", fmt.Errorf("failed to get %s: %w", c.Secret, err)
+		}
+		value, ok := secret.Data[c.Secret.Key]
+		if !ok {
+			return "", fmt.Errorf("%s has no such key", c.Secret)
+		}
+		return strings.TrimSpace(string(value)), nil
+	case len(c.File) > 0:
+		value, err := os.ReadFile(c.File)
+		if err != nil {
+			return "", fmt.Errorf("failed to read credential file: %w", err)
+		}
+		return strings.TrimSpace(string(value)), nil
+	default:
+		return "", errors.New("credential has no source")
+	}
+}
+
+// readCredential returns the credential of the key given as a file in
+// <key>_file or as a Secret reference in the key itself, nil for a plain value
+func readCredential(cfg *Config, key string) (*Credential, error) {
+	value, file := cfg.viper.GetString(key), cfg.viper.GetString(key+"_file")
+	if len(file) > 0 {
+		if len(value) > 0 {
+			return nil, fmt.Errorf("%s and %s_file are mutually exclusive", key, key)
+		}
+		return &Credential{File: file}, nil
+	}
+	ref, ok, err := ParseSecretRef(value)
+	if !ok || err != nil {
+		return nil, err
+	}
+	return &Credential{Secret: ref}, nil
+}
+
+// Dialector returns the database dialector. With credentials from a file or a
+// Secret the connection string is resolved again on every call, so each store
+// attempt uses the current one.
+func (cfg *Config) Dialector(ctx context.Context) (gorm.Dialector, error) {
+	if cfg.DatabaseCredential == nil {
+		if cfg.DatabaseDialector == nil {
+			return nil, errors.New("database connection string is not set")
+		}
+		return cfg.DatabaseDialector, nil
+	}
+	dsn, err := cfg.DatabaseCredential.Resolve(ctx, cfg.K8sClient)
+	if err != nil {
+		return nil, err
+	}
+	return OpenDialector(dsn)
+}
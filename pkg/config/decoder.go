@@ -3,16 +3,16 @@ package config
 import (
 	"errors"
 	"fmt"
-	"net"
 	"net/url"
 	"reflect"
-	"regexp"
 	"strings"
 
 	"gopkg.in/yaml.v3"
+	"gorm.io/driver/clickhouse"
 	"gorm.io/driver/mysql"
 	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
+	"gorm.io/driver/sqlserver"
 	"gorm.io/gorm"
 )
 
@@ -35,11 +35,57 @@ func decodeArgs(f reflect.Type, t reflect.Type, data any) (any, error) {
 			args[key] = str
 		}
 		return args, nil
+	case reflect.TypeFor[map[string]any]():
+		// Args from a config file, flags without a value are left empty
+		args := Args{}
+		for key, value := range data.(map[string]any) {
+			switch value.(type) {
+			case nil:
+				args[key] = ""
+			case map[string]any, []any:
+				return nil, fmt.Errorf("args values must be scalars, but key %s has value: %v", key, value)
+			default:
+				args[key] = fmt.Sprint(value)
+			}
+		}
+		return args, nil
 	default:
 		return nil, fmt.Errorf("unsupported args type: %T", data)
 	}
 }
 
+func decodeLabels(f reflect.Type, t reflect.Type, data any) (any, error) {
+	if t != reflect.TypeFor[LabelMap]() {
+		return data, nil
+	}
+	labels := LabelMap{}
+	switch f {
+	case reflect.TypeFor[string]():
+		for _, pair := range strings.Split(data.(string), ",") {
+			if len(strings.TrimSpace(pair)) == 0 {
+				continue
+			}
+			key, value, ok := strings.Cut(pair, "=")
+			key = strings.TrimSpace(key)
+			if !ok || len(key) == 0 {
+				return nil, fmt.Errorf("labels must be key=value pairs, got %q", pair)
+			}
+			labels[key] = strings.TrimSpace(value)
+		}
+	case reflect.TypeFor[map[string]any]():
+		for key, value := range data.(map[string]any) {
+			labels[key] = fmt.Sprint(value)
+		}
+	case reflect.TypeFor[map[string]string]():
+		for key, value := range data.(map[string]string) {
+			labels[key] = value
+		}
+	default:
+		return nil, fmt.Errorf("unsupported labels type: %T", data)
+	}
+	return labels, nil
+}
+
 func decodeMode(f reflect.Type, t reflect.Type, data any) (any, error) {
 	if t != reflect.TypeFor[Mode]() {
 		return data, nil
@@ -51,6 +97,8 @@ func decodeMode(f reflect.Type, t reflect.Type, data any) (any, error) {
 			return ModeClient, nil
 		case "server":
 			return ModeServer, nil
+		case "dns":
+			return ModeDNS, nil
 		default:
 			return nil, fmt.Errorf("unsupported mode: %s", data.(string))
 		}
@@ -59,26 +107,66 @@ func decodeMode(f reflect.Type, t reflect.Type, data any) (any, error) {
 	}
 }
 
+func decodeTargetType(f reflect.Type, t reflect.Type, data any) (any, error) {
+	if t != reflect.TypeFor[TargetType]() {
+		return data, nil
+	}
+	if f != reflect.TypeFor[string]() {
+		return nil, fmt.Errorf("unsupported target type: %T", data)
+	}
+	target := TargetType(strings.ToLower(strings.TrimSpace(data.(string))))
+	switch target {
+	case TargetPod, TargetClusterIP, TargetNodePort, TargetHeadless:
+		return target, nil
+	default:
+		return nil, fmt.Errorf("unsupported target: %s", data.(string))
+	}
+}
+
 func decodeServer(f reflect.Type, t reflect.Type, data any) (any, error) {
 	if t != reflect.TypeFor[Address]() {
 		return data, nil
 	}
 	switch f {
 	case reflect.TypeFor[string]():
-		domainRegex := `^([a-zA-Z0-9-]+\.)*[a-zA-Z]{2,}$`
-		str := strings.TrimSpace(data.(string))
-		if regexp.MustCompile(domainRegex).MatchString(str) {
-			return Address(str), nil
+		address := Address(strings.TrimSpace(data.(string)))
+		kind, _, _, err := address.Reference()
+		if err != nil {
+			return nil, err
 		}
-		if net.ParseIP(str) != nil {
-			return Address(str), nil
+		if len(kind) > 0 {
+			return address, nil
 		}
-		return nil, fmt.Errorf("server is neither domain nor IP: %s", str)
+		if _, _, err = address.SplitHostPort(); err != nil {
+			return nil, err
+		}
+		return address, nil
 	default:
 		return nil, fmt.Errorf("unsupported server type: %T", data)
 	}
 }
 
+func decodeIPFamily(f reflect.Type, t reflect.Type, data any) (any, error) {
+	if t != reflect.TypeFor[IPFamily]() {
+		return data, nil
+	}
+	if f != reflect.TypeFor[string]() {
+		return nil, fmt.Errorf("unsupported IP family type: %T", data)
+	}
+	switch strings.ToLower(strings.TrimSpace(data.(string))) {
+	case "", "any":
+		return IPFamilyAny, nil
+	case "4", "ipv4":
+		return IPFamilyIPv4, nil
+	case "6", "ipv6":
+		return IPFamilyIPv6, nil
+	case "dual":
+		return IPFamilyDual, nil
+	default:
+		return nil, fmt.Errorf("unsupported IP family %q, use ipv4, ipv6 or dual", data.(string))
+	}
+}
+
 func decodeURL(f reflect.Type, t reflect.Type, data any) (any, error) {
 	if t != reflect.TypeFor[*url.URL]() {
 		return data, nil
@@ -104,19 +192,37 @@ func decodeDatabaseDialector(f reflect.Type, t reflect.Type, data any) (any, err
 	if f != reflect.TypeFor[string]() {
 		return nil, fmt.Errorf("unsupported database connection string type: %T", data)
 	}
-	dsn := data.(string)
-	parsedURL, err := url.Parse(dsn)
-	if err != nil {
-		return nil, fmt.Errorf("invalid DSN: %w", err)
+	if strings.HasPrefix(data.(string), secretScheme) {
+		// Resolved through the Kubernetes client when the database is opened
+		return nil, nil
 	}
-	switch parsedURL.Scheme {
+	return OpenDialector(data.(string))
+}
+
+// OpenDialector returns the dialector of a database connection string URL
+func OpenDialector(dsn string) (gorm.Dialector, error) {
+	// Only the scheme is looked at, MySQL driver DSNs like tcp(host:port)
+	// aren't valid URLs
+	scheme, _, found := strings.Cut(dsn, "://")
+	if !found {
+		return nil, errors.New("invalid DSN, expected a URL like postgres://host/database")
+	}
+	switch scheme {
 	case "postgres", "postgresql":
 		return postgres.Open(dsn), nil
 	case "mysql":
-		return mysql.Open(dsn), nil
+		return mysql.Open(mysqlDSN(dsn)), nil
 	case "sqlite":
 		return sqlite.Open(strings.Replace(dsn, "sqlite://", "file:", 1)), nil
+	case "sqlserver":
+		return sqlserver.Open(dsn), nil
+	case "clickhouse":
+		httpDSN, err := clickhouseDSN(dsn)
+		if err != nil {
+			return nil, err
+		}
+		return clickhouse.Open(httpDSN), nil
 	default:
-		return nil, fmt.Errorf("unsupported database type: %s", parsedURL.Scheme)
+		return nil, fmt.Errorf("unsupported database type: %s", scheme)
 	}
 }
@@ -6,9 +6,11 @@ import (
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"gorm.io/driver/clickhouse"
 	"gorm.io/driver/mysql"
 	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
+	"gorm.io/driver/sqlserver"
 	"gorm.io/gorm"
 )
 
@@ -38,11 +40,40 @@ var _ = Describe("Decoder", func() {
 		})
 	})
 
+	Context("LabelMap", func() {
+		It("should decode key=value pairs and maps", func() {
+			for input, expected := range map[any]LabelMap{
+				"encryption=wireguard, kube-proxy=replaced,mtu=9000": {
+					"encryption": "wireguard", "kube-proxy": "replaced", "mtu": "9000",
+				},
+				"":         {},
+				"empty=":   {"empty": ""},
+				"a=b=c,,":  {"a": "b=c"},
+				"mtu=1500": {"mtu": "1500"},
+			} {
+				output, err := decodeLabels(reflect.TypeOf(input), reflect.TypeFor[LabelMap](), input)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(output).To(Equal(expected))
+			}
+			output, err := decodeLabels(reflect.TypeFor[map[string]any](), reflect.TypeFor[LabelMap](), map[string]any{"mtu": 9000})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(output).To(Equal(LabelMap{"mtu": "9000"}))
+		})
+
+		It("should return an error", func() {
+			for _, input := range []any{"novalue", "=value", "a=b,c", true, 3.14, []string{"a=b"}} {
+				_, err := decodeLabels(reflect.TypeOf(input), reflect.TypeFor[LabelMap](), input)
+				Expect(err).To(HaveOccurred())
+			}
+		})
+	})
+
 	Context("Mode", func() {
 		It("should parse a valid mode", func() {
 			for input, expected := range map[string]Mode{
 				"Client": ModeClient, "client": ModeClient, "CLIENT": ModeClient,
 				"server": ModeServer, "Server": ModeServer, "SERVER": ModeServer,
+				"dns": ModeDNS, "DNS": ModeDNS,
 			} {
 				output, err := decodeMode(reflect.TypeOf(input), reflect.TypeOf(expected), input)
 				Expect(err).ToNot(HaveOccurred())
@@ -63,12 +94,34 @@ var _ = Describe("Decoder", func() {
 		})
 	})
 
+	Context("TargetType", func() {
+		It("should decode known target types", func() {
+			for input, expected := range map[string]TargetType{
+				"pod": TargetPod, "CLUSTER-IP": TargetClusterIP, "node-port": TargetNodePort, " headless ": TargetHeadless,
+			} {
+				output, err := decodeTargetType(reflect.TypeOf(input), reflect.TypeOf(expected), input)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(output).To(Equal(expected))
+			}
+		})
+
+		It("should return an error", func() {
+			for _, input := range []any{"", "ingress", true, 3.14} {
+				_, err := decodeTargetType(reflect.TypeOf(input), reflect.TypeFor[TargetType](), input)
+				Expect(err).To(HaveOccurred())
+			}
+		})
+	})
+
 	Context("Server", func() {
 		It("should decode valid domain from single string value", func() {
 			for input, expected := range map[string]Address{
-				"localhost":   "localhost",
-				"example.com": "example.com",
-				"a.b.c.d.efg": "a.b.c.d.efg",
+				"localhost":                               "localhost",
+				"example.com":                             "example.com",
+				"a.b.c.d.efg":                             "a.b.c.d.efg",
+				"pod:bench/app=server,role in (iperf3)":   "pod:bench/app=server,role in (iperf3)",
+				"service:bench/server":                    "service:bench/server",
+				"pod:bench/app.kubernetes.io/name=iperf3": "pod:bench/app.kubernetes.io/name=iperf3",
 			} {
 				output, err := decodeServer(reflect.TypeOf(input), reflect.TypeOf(expected), input)
 				Expect(err).ToNot(HaveOccurred())
@@ -80,6 +133,7 @@ var _ = Describe("Decoder", func() {
 			for _, input := range []any{
 				",", "", "*", "*.*", "invalid..com",
 				"*.wildcard.com", "two.domains,go.here",
+				"pod:bench", "pod:/app=server", "pod:bench/app=(x", "service:bench/a/b", "service:/server",
 				[]string{"array"},
 				[]map[string]string{{"a": "b"}},
 				true, 3.14,
@@ -122,10 +176,12 @@ var _ = Describe("Decoder", func() {
 	Context("DatabaseDialector", func() {
 		It("should decode valid connection string", func() {
 			for input, expected := range map[string]reflect.Type{
-				"mysql://u:p@l:3306/d":           reflect.TypeFor[*mysql.Dialector](),
-				"postgresql://u:p@l:5432/d":      reflect.TypeFor[*postgres.Dialector](),
-				"postgres://u:p@l:5432/d":        reflect.TypeFor[*postgres.Dialector](),
-				"sqlite://:memory:?cache=shared": reflect.TypeFor[*sqlite.Dialector](),
+				"mysql://u:p@l:3306/d":              reflect.TypeFor[*mysql.Dialector](),
+				"postgresql://u:p@l:5432/d":         reflect.TypeFor[*postgres.Dialector](),
+				"postgres://u:p@l:5432/d":           reflect.TypeFor[*postgres.Dialector](),
+				"sqlite://:memory:?cache=shared":    reflect.TypeFor[*sqlite.Dialector](),
+				"sqlserver://u:p@l:1433?database=d": reflect.TypeFor[*sqlserver.Dialector](),
+				"clickhouse://u:p@l:9000/d":         reflect.TypeFor[*clickhouse.Dialector](),
 			} {
 				output, err := decodeDatabaseDialector(reflect.TypeOf(input), reflect.TypeFor[gorm.Dialector](), input)
 				Expect(err).ToNot(HaveOccurred())
@@ -133,6 +189,32 @@ var _ = Describe("Decoder", func() {
 			}
 		})
 
+		It("should convert MySQL URLs to the driver format", func() {
+			for input, expected := range map[string]string{
+				"mysql://u:p@l:3306/d?parseTime=true": "u:p@tcp(l:3306)/d?parseTime=true",
+				"mysql://u:p@ss@l/d":                  "u:p@ss@tcp(l)/d",
+				"mysql://u:p@tcp(l:3306)/d":           "u:p@tcp(l:3306)/d",
+			} {
+				output, err := decodeDatabaseDialector(reflect.TypeOf(input), reflect.TypeFor[gorm.Dialector](), input)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(output.(*mysql.Dialector).Config.DSN).To(Equal(expected))
+			}
+		})
+
+		It("should point ClickHouse URLs to the HTTP interface", func() {
+			for input, expected := range map[string]string{
+				"clickhouse://u:p@l/d":             "http://u:p@l:8123/d",
+				"clickhouse://u:p@l/d?secure=true": "https://u:p@l:8443/d?secure=true",
+				"clickhouse://u:p@l:18123/d":       "http://u:p@l:18123/d",
+				"clickhouse://u:p@l:9000/d":        "http://u:p@l:8123/d",
+				"clickhouse://u:p@l:9440/d":        "https://u:p@l:8443/d",
+			} {
+				output, err := decodeDatabaseDialector(reflect.TypeOf(input), reflect.TypeFor[gorm.Dialector](), input)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(output.(*clickhouse.Dialector).Config.DSN).To(Equal(expected))
+			}
+		})
+
 		It("should fail for wrong connection strings", func() {
 			for _, input := range []any{
 				"wrong://u:p@l:3306/d", "",
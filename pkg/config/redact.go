@@ -0,0 +1,77 @@
+package config
+
+import (
+	"cmp"
+	"strings"
+
+	"gorm.io/driver/clickhouse"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/driver/sqlserver"
+	"gorm.io/gorm"
+
+	"cni-benchmark/pkg/logging"
+)
+
+// loggedConfig has no MarshalLog method, so it is logged field by field
+type loggedConfig Config
+
+// MarshalLog returns the configuration as logged, with the clients dropped and
+// credentials masked in the database URL and iperf3 arguments
+func (cfg *Config) MarshalLog() any {
+	if cfg == nil {
+		return nil
+	}
+	view := loggedConfig(*cfg)
+	view.K8sClient = nil
+	view.DatabaseDialector = nil
+	view.Args = make(Args, len(cfg.Args))
+	for key, value := range cfg.Args {
+		view.Args[key] = logging.RedactValue(key, value)
+	}
+	view.Command = cfg.RedactedCommand()
+	return struct {
+		loggedConfig
+		DatabaseURL string
+	}{view, cmp.Or(databaseSecret(cfg.DatabaseCredential), logging.Redact(DatabaseDSN(cfg.DatabaseDialector)))}
+}
+
+// RedactedCommand returns the iperf3 command with credentials masked
+func (cfg *Config) RedactedCommand() []string {
+	command := make([]string, len(cfg.Command))
+	for i, arg := range cfg.Command {
+		if key, value, ok := strings.Cut(arg, "="); ok {
+			arg = key + "=" + logging.RedactValue(key, value)
+		}
+		command[i] = arg
+	}
+	return command
+}
+
+// databaseSecret returns the Secret reference of the credential, which is
+// not sensitive itself
+func databaseSecret(credential *Credential) string {
+	if credential == nil || credential.Secret == nil {
+		return ""
+	}
+	return credential.Secret.String()
+}
+
+// DatabaseDSN returns the connection string the dialector was opened with
+func DatabaseDSN(dialector gorm.Dialector) string {
+	switch d := dialector.(type) {
+	case *postgres.Dialector:
+		return d.Config.DSN
+	case *mysql.Dialector:
+		return d.Config.DSN
+	case *sqlite.Dialector:
+		return d.DSN
+	case *sqlserver.Dialector:
+		return d.Config.DSN
+	case *clickhouse.Dialector:
+		return d.Config.DSN
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// durationPattern matches strings accepted by time.ParseDuration
+const durationPattern = `^0$|^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`
+
+// Schema returns the JSON Schema of the configuration file generated from the
+// Config struct, keys are the ones of environment variables in lowercase and
+// nested, e.g. LEASE_RENEW_DEADLINE is lease.renew_deadline
+func Schema() ([]byte, error) {
+	schema := schemaOf(reflect.TypeFor[Config]())
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "cni-benchmark configuration"
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+func schemaOf(t reflect.Type) map[string]any {
+	switch t {
+	case reflect.TypeFor[time.Duration]():
+		return map[string]any{"type": "string", "pattern": durationPattern}
+	case reflect.TypeFor[Mode]():
+		return map[string]any{"enum": []string{ModeClient.String(), ModeServer.String(), ModeDNS.String()}}
+	case reflect.TypeFor[TargetType]():
+		return map[string]any{"enum": []TargetType{TargetPod, TargetClusterIP, TargetNodePort, TargetHeadless}}
+	case reflect.TypeFor[IPFamily]():
+		return map[string]any{"enum": []IPFamily{IPFamilyAny, "any", "4", "6", IPFamilyIPv4, IPFamilyIPv6, IPFamilyDual}}
+	case reflect.TypeFor[gorm.Dialector]():
+		return map[string]any{"type": "string", "pattern": "^(postgres|postgresql|mysql|sqlite|sqlserver|clickhouse|secret)://"}
+	case reflect.TypeFor[Args]():
+		return map[string]any{
+			"type":                 "object",
+			"additionalProperties": map[string]any{"type": []string{"string", "number", "boolean", "null"}},
+		}
+	case reflect.TypeFor[LabelMap]():
+		return map[string]any{
+			"oneOf": []any{
+				map[string]any{"type": "object", "additionalProperties": map[string]any{"type": "string"}},
+				map[string]any{"type": "string", "description": "comma-separated key=value pairs"},
+			},
+		}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]any{"type": "integer", "minimum": 0, "maximum": uint64(1)<<t.Bits() - 1}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice:
+		// Lists may also be given as comma-separated strings
+		return map[string]any{"oneOf": []any{
+			map[string]any{"type": "array", "items": schemaOf(t.Elem())},
+			map[string]any{"type": "string"},
+		}}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaOf(t.Elem())}
+	case reflect.Struct:
+		properties := map[string]any{}
+		for i := range t.NumField() {
+			field := t.Field(i)
+			if key := field.Tag.Get("mapstructure"); len(key) > 0 && key != "-" && field.IsExported() {
+				properties[key] = schemaOf(field.Type)
+			}
+		}
+		return map[string]any{"type": "object", "properties": properties, "additionalProperties": false}
+	default:
+		return map[string]any{}
+	}
+}
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"time"
+
 	"github.com/spf13/viper"
 	"gorm.io/gorm"
 	"k8s.io/client-go/kubernetes"
@@ -8,16 +10,22 @@ import (
 
 // Config holds the application configuration loaded from environment variables.
 type Config struct {
-	viper     *viper.Viper `json:"-" yaml:"-"`
-	K8sClient *kubernetes.Clientset
-	Lease     Lease `mapstructure:"lease"`
+	viper     *viper.Viper         `json:"-" yaml:"-"`
+	K8sClient kubernetes.Interface `json:"-"`
+	Lease     Lease                `mapstructure:"lease"`
 	Command   []string
 	// Name of the test case we run
 	TestCase string `mapstructure:"test_case"`
 	// iperf3 server address
 	Server Address `mapstructure:"server"`
 	// Database connection string URL is parsed to Dialector
-	DatabaseDialector gorm.Dialector `mapstructure:"database_url"`
+	DatabaseDialector gorm.Dialector `mapstructure:"database_url" json:"-"`
+	// File with the connection string URL, read again on every store attempt
+	DatabaseURLFile string `mapstructure:"database_url_file"`
+	// Connection string source when it is a file or a Secret reference
+	DatabaseCredential *Credential `mapstructure:"-" json:"-"`
+	// TLS, timeouts, pool sizing and table naming of the database connection
+	Database Database `mapstructure:"database"`
 	// Total test duration
 	Duration uint16 `mapstructure:"duration"`
 	// Extra args to iperf3
@@ -26,24 +34,278 @@ type Config struct {
 	Port uint16 `mapstructure:"port"`
 	// Mode to run in: client or server
 	Mode Mode `mapstructure:"mode"`
+	// IP family to benchmark in, both in turn when dual
+	IPFamily IPFamily `mapstructure:"ip_family"`
+	// Run without Kubernetes, info comes from the environment and the host
+	Standalone bool `mapstructure:"standalone"`
 	// Align all data points starting from midday
 	AlignTime bool `mapstructure:"align_time"`
+	// DNS resolution benchmark settings
+	DNS DNS `mapstructure:"dns"`
+	// Targets resolved from the server Service to benchmark in turn
+	Targets Targets `mapstructure:"targets"`
+	// Client pod passed through the downward API
+	Pod Pod `mapstructure:"pod"`
+	// Cluster environment detection settings
+	Detect Detect `mapstructure:"detect"`
+	// User-defined labels stored with each run
+	ExtraLabels LabelMap `mapstructure:"extra_labels"`
+	// ConfigMap reference as namespace/name with more labels
+	LabelsConfigMap string `mapstructure:"labels_configmap"`
+	// Where to read environment information overrides from
+	Info InfoSource `mapstructure:"info"`
+	// Run progress written back to the cluster
+	Status Status `mapstructure:"status"`
+	// Run even when the test case is marked as completed
+	ForceRerun bool `mapstructure:"force_rerun"`
+	// Cluster-wide queue serializing runs across test cases
+	Queue Queue `mapstructure:"queue"`
+	// iperf3 servers supervision in server mode
+	Supervisor Supervisor `mapstructure:"supervisor"`
+	// Prometheus metrics of the benchmark process
+	Metrics Metrics `mapstructure:"metrics"`
+	// OpenTelemetry tracing of the benchmark phases
+	Tracing Tracing `mapstructure:"tracing"`
+	// Log level and format
+	Log Log `mapstructure:"log"`
+}
+
+type Database struct {
+	// TLS towards the database server
+	TLS DatabaseTLS `mapstructure:"tls"`
+	// Timeout of establishing a connection, zero keeps the driver default
+	ConnectTimeout time.Duration `mapstructure:"connect_timeout"`
+	// Connection pool sizing, zero keeps the database/sql defaults
+	MaxOpenConns    int           `mapstructure:"max_open_conns"`
+	MaxIdleConns    int           `mapstructure:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `mapstructure:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration `mapstructure:"conn_max_idle_time"`
+	// Schema to create the tables in
+	Schema string `mapstructure:"schema"`
+	// Prefix of the table names
+	TablePrefix string `mapstructure:"table_prefix"`
+	// TimescaleDB layout of the iperf3 intervals on Postgres
+	Timescale Timescale `mapstructure:"timescale"`
+}
+
+type Timescale struct {
+	// Enabled turns the metrics table into a hypertable with continuous
+	// aggregates during migration, when the extension is installed
+	Enabled bool `mapstructure:"enabled"`
+	// Time range of a hypertable chunk, zero keeps the TimescaleDB default
+	ChunkInterval time.Duration `mapstructure:"chunk_interval"`
+	// Age after which intervals are dropped, zero keeps them
+	Retention time.Duration `mapstructure:"retention"`
+	// Age after which aggregated buckets are dropped, zero keeps them
+	AggregateRetention time.Duration `mapstructure:"aggregate_retention"`
+}
+
+// TimescaleRefreshWindow is how far back continuous aggregates are refreshed,
+// intervals must be kept at least that long
+const TimescaleRefreshWindow = 48 * time.Hour
+
+type DatabaseTLS struct {
+	// Mode like the Postgres sslmode: disable, require, verify-ca or
+	// verify-full, empty keeps what the connection string says
+	Mode string `mapstructure:"mode"`
+	// PEM files of the CA bundle and the client certificate and key
+	CA   string `mapstructure:"ca"`
+	Cert string `mapstructure:"cert"`
+	Key  string `mapstructure:"key"`
+	// Name verified against the server certificate, defaults to the host
+	ServerName string `mapstructure:"server_name"`
+}
+
+type Log struct {
+	// Level name like debug, info or error, or a logr verbosity like 2
+	Level string `mapstructure:"level"`
+	// Format of log lines: console or json
+	Format string `mapstructure:"format"`
+}
+
+type Tracing struct {
+	// OTLP/HTTP endpoint URL like http://collector:4318, empty disables it
+	Endpoint string `mapstructure:"endpoint"`
+	// Fraction of runs to trace
+	SampleRatio float64 `mapstructure:"sample_ratio"`
+}
+
+type Metrics struct {
+	// Address serving /metrics, empty disables it
+	Address string `mapstructure:"address"`
+	// Keep serving after a client run so the final values get scraped
+	Linger time.Duration `mapstructure:"linger"`
+}
+
+type Supervisor struct {
+	// Number of iperf3 servers on consecutive ports starting at Port
+	Servers int `mapstructure:"servers"`
+	// Address serving /healthz and /readyz, empty disables it
+	HealthAddress string `mapstructure:"health_address"`
+	// Restart backoff of crashed servers
+	InitialBackoff time.Duration `mapstructure:"initial_backoff"`
+	MaxBackoff     time.Duration `mapstructure:"max_backoff"`
+}
+
+type Queue struct {
+	// ConfigMap holding the queue, the queue is disabled when empty
+	ConfigMap string `mapstructure:"configmap"`
+	// Defaults to the Lease namespace
+	Namespace string `mapstructure:"namespace"`
+	// Number of runs allowed at the same time
+	Slots int `mapstructure:"slots"`
+	// Hand out slots per client and server node pair instead of cluster-wide
+	PerNodePair  bool          `mapstructure:"per_node_pair"`
+	PollInterval time.Duration `mapstructure:"poll_interval"`
+	// Entries without a heartbeat for this long are dropped
+	TTL time.Duration `mapstructure:"ttl"`
+}
+
+type Status struct {
+	// Emit Events against the client pod or the Lease
+	Events bool `mapstructure:"events"`
+	// ConfigMap in the Lease namespace with the last result of each test case
+	ConfigMap string `mapstructure:"configmap"`
+}
+
+type InfoSource struct {
+	Namespace    string `mapstructure:"namespace"`
+	OSConfigMap  string `mapstructure:"os_configmap"`
+	K8sConfigMap string `mapstructure:"k8s_configmap"`
+	CNIConfigMap string `mapstructure:"cni_configmap"`
+	// Single ConfigMap with all keys, replaces the three above when set
+	ConfigMap string `mapstructure:"configmap"`
+	// Prefix prepended to every key, e.g. BENCH_ for BENCH_OS_NAME
+	KeyPrefix string `mapstructure:"key_prefix"`
+	// Values of the keys in standalone mode by their lowercase name without
+	// the prefix, e.g. os_name, also read from the prefixed variables
+	Values map[string]string `mapstructure:"values"`
+}
+
+// InfoKeys are the keys of the environment information, read from the info
+// ConfigMaps or from Values in standalone mode
+var InfoKeys = []string{
+	"OS_NAME", "OS_VERSION",
+	"K8S_PROVIDER", "K8S_PROVIDER_VERSION", "K8S_VERSION", "K8S_CONTAINER_RUNTIME", "K8S_KUBELET_VERSION",
+	"CNI_NAME", "CNI_VERSION", "CNI_DESCRIPTION",
+}
+
+type Detect struct {
+	// Namespaces to look for CNI agent DaemonSets in
+	Namespaces []string `mapstructure:"namespaces"`
+	// Node CNI configuration directory mounted through a hostPath
+	CNIConfDir string `mapstructure:"cni_conf_dir"`
+	// procfs and sysfs to take the host networking snapshot from
+	ProcDir string `mapstructure:"proc_dir"`
+	SysDir  string `mapstructure:"sys_dir"`
+	// OS name and version source in standalone mode
+	OSRelease string `mapstructure:"os_release"`
+}
+
+type Pod struct {
+	Name      string `mapstructure:"name"`
+	Namespace string `mapstructure:"namespace"`
+	NodeName  string `mapstructure:"node_name"`
 }
 
 type Lease struct {
 	Namespace string `mapstructure:"namespace"`
 	Name      string `mapstructure:"name"`
 	ID        string `mapstructure:"id"`
+	// Lock backend, none disables the leader election
+	Lock          string        `mapstructure:"lock"`
+	Duration      time.Duration `mapstructure:"duration"`
+	RenewDeadline time.Duration `mapstructure:"renew_deadline"`
+	RetryPeriod   time.Duration `mapstructure:"retry_period"`
+}
+
+type DNS struct {
+	// Names to resolve on every round
+	Names []string `mapstructure:"names"`
+	// Explicit resolver address, the pod's resolver is used when empty
+	Server string `mapstructure:"server"`
+	// Delay between resolution rounds
+	Interval time.Duration `mapstructure:"interval"`
+	// Time to wait for a single answer before counting it as a timeout
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+type Targets struct {
+	// Server Service reference as namespace/name
+	Service string `mapstructure:"service"`
+	// Headless Service name in the same namespace, defaults to the server Service
+	HeadlessService string `mapstructure:"headless_service"`
+	// Kinds of targets to benchmark in order
+	Types []TargetType `mapstructure:"types"`
+	// Cluster domain used to build headless Service FQDN
+	ClusterDomain string `mapstructure:"cluster_domain"`
 }
 
 type (
-	Args    map[string]string
-	Port    uint16
-	Address string
-	Mode    uint8
+	Args     map[string]string
+	LabelMap map[string]string
+	Port     uint16
+	Address  string
+	Mode     uint8
+	// TargetType is a way to reach the server
+	TargetType string
+	// IPFamily selects IPv4, IPv6 or both, empty lets the resolver decide
+	IPFamily string
 )
 
 const (
 	ModeClient Mode = iota
 	ModeServer
+	ModeDNS
+)
+
+// MarshalText writes the mode by name in logs and dumps
+func (m Mode) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m Mode) String() string {
+	switch m {
+	case ModeClient:
+		return "client"
+	case ModeServer:
+		return "server"
+	case ModeDNS:
+		return "dns"
+	}
+	return "unknown"
+}
+
+// Server references resolved through the Kubernetes API
+const (
+	ReferencePod     = "pod"
+	ReferenceService = "service"
+)
+
+const (
+	TargetPod       TargetType = "pod"
+	TargetClusterIP TargetType = "cluster-ip"
+	TargetNodePort  TargetType = "node-port"
+	TargetHeadless  TargetType = "headless"
+)
+
+const (
+	IPFamilyAny  IPFamily = ""
+	IPFamilyIPv4 IPFamily = "ipv4"
+	IPFamilyIPv6 IPFamily = "ipv6"
+	IPFamilyDual IPFamily = "dual"
+)
+
+// Database TLS modes, named after the Postgres sslmode values
+const (
+	TLSDisable    = "disable"
+	TLSRequire    = "require"
+	TLSVerifyCA   = "verify-ca"
+	TLSVerifyFull = "verify-full"
+)
+
+// Leader election lock backends
+const (
+	LockLeases = "leases"
+	LockNone   = "none"
 )
@@ -0,0 +1,61 @@
+package detect
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Node is what the kubelet reports about its node
+type Node struct {
+	OSName           string
+	OSVersion        string
+	KernelVersion    string
+	ContainerRuntime string
+	KubeletVersion   string
+}
+
+// KubernetesVersion returns the API server version without the v prefix
+func KubernetesVersion(client kubernetes.Interface) (string, error) {
+	version, err := client.Discovery().ServerVersion()
+	if err != nil {
+		return "", fmt.Errorf("failed to get server version: %w", err)
+	}
+	return strings.TrimPrefix(version.GitVersion, "v"), nil
+}
+
+// NodeInfo reads the node status reported by the kubelet
+func NodeInfo(ctx context.Context, client kubernetes.Interface, name string) (*Node, error) {
+	node, err := client.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node %s: %w", name, err)
+	}
+	status := node.Status.NodeInfo
+	osName, osVersion := splitOSImage(status.OSImage)
+	return &Node{
+		OSName:           osName,
+		OSVersion:        osVersion,
+		KernelVersion:    status.KernelVersion,
+		ContainerRuntime: status.ContainerRuntimeVersion,
+		KubeletVersion:   strings.TrimPrefix(status.KubeletVersion, "v"),
+	}, nil
+}
+
+// splitOSImage splits an OS image like "Ubuntu 24.04.1 LTS" into name and
+// version at the first word which starts with a digit, "v<digit>" or "("
+func splitOSImage(image string) (name, version string) {
+	words := strings.Fields(image)
+	for i, word := range words {
+		first := rune(word[0])
+		versionLike := unicode.IsDigit(first) || first == '(' ||
+			(len(word) > 1 && first == 'v' && unicode.IsDigit(rune(word[1])))
+		if i > 0 && versionLike {
+			return strings.Join(words[:i], " "), strings.Join(words[i:], " ")
+		}
+	}
+	return strings.Join(words, " "), ""
+}
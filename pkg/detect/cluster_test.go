@@ -0,0 +1,59 @@
+package detect_test
+
+import (
+	"cni-benchmark/pkg/detect"
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Describe("Cluster", func() {
+	It("should return the API server version", func() {
+		client := fake.NewClientset()
+		client.Discovery().(*fakediscovery.FakeDiscovery).FakedServerVersion = &version.Info{GitVersion: "v1.32.1"}
+		v, err := detect.KubernetesVersion(client)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(v).To(Equal("1.32.1"))
+	})
+
+	It("should read node info reported by the kubelet", func() {
+		for image, expected := range map[string][2]string{
+			"Ubuntu 24.04.1 LTS":                                 {"Ubuntu", "24.04.1 LTS"},
+			"Debian GNU/Linux 12 (bookworm)":                     {"Debian GNU/Linux", "12 (bookworm)"},
+			"Talos (v1.9.1)":                                     {"Talos", "(v1.9.1)"},
+			"Bottlerocket OS 1.29.0 (aws-k8s-1.32)":              {"Bottlerocket OS", "1.29.0 (aws-k8s-1.32)"},
+			"Flatcar Container Linux by Kinvolk 4081.2.0 (Oklo)": {"Flatcar Container Linux by Kinvolk", "4081.2.0 (Oklo)"},
+			"Container-Optimized OS from Google":                 {"Container-Optimized OS from Google", ""},
+		} {
+			client := fake.NewClientset(&corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "worker"},
+				Status: corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{
+					OSImage:                 image,
+					KernelVersion:           "6.8.0-51-generic",
+					ContainerRuntimeVersion: "containerd://1.7.24",
+					KubeletVersion:          "v1.32.1",
+				}},
+			})
+			node, err := detect.NodeInfo(context.Background(), client, "worker")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(node).To(Equal(&detect.Node{
+				OSName:           expected[0],
+				OSVersion:        expected[1],
+				KernelVersion:    "6.8.0-51-generic",
+				ContainerRuntime: "containerd://1.7.24",
+				KubeletVersion:   "1.32.1",
+			}), image)
+		}
+	})
+
+	It("should fail for an unknown node", func() {
+		_, err := detect.NodeInfo(context.Background(), fake.NewClientset(), "missing")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,160 @@
+package detect
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	config "cni-benchmark/pkg/config"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// CNI is a detected network plugin
+type CNI struct {
+	Name    string
+	Version string
+	// Where the plugin was detected from
+	Source string
+}
+
+// plugin describes how to recognize a known CNI
+type plugin struct {
+	name string
+	// Image repository suffixes of the agent
+	images []string
+	// Plugin types or file name parts in the CNI config
+	confTypes []string
+}
+
+var plugins = []plugin{
+	{"cilium", []string{"cilium/cilium"}, []string{"cilium-cni", "cilium"}},
+	{"calico", []string{"calico/node"}, []string{"calico"}},
+	{"flannel", []string{"flannel-io/flannel", "flannel/flannel", "coreos/flannel"}, []string{"flannel"}},
+	{"antrea", []string{"antrea/antrea-agent-ubuntu", "antrea/antrea-ubuntu", "antrea/antrea-agent"}, []string{"antrea"}},
+	{"kube-router", []string{"cloudnativelabs/kube-router"}, []string{"kuberouter", "kube-router"}},
+	{"weave", []string{"weaveworks/weave-kube", "rajchaudhuri/weave-kube"}, []string{"weave-net", "weave"}},
+}
+
+// Plugin identifies the CNI by agent DaemonSet images, falling back to
+// the node CNI configuration directory which only gives the name
+func Plugin(ctx context.Context, client kubernetes.Interface, cfg config.Detect) (*CNI, error) {
+	if client != nil {
+		for _, namespace := range cfg.Namespaces {
+			daemonSets, err := client.AppsV1().DaemonSets(namespace).List(ctx, metav1.ListOptions{})
+			if apierrors.IsForbidden(err) {
+				// Access may be granted only to the namespaces in use
+				logf.FromContext(ctx).V(1).Info("not allowed to list daemonsets, skipping", "namespace", namespace)
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to list daemonsets in %s: %w", namespace, err)
+			}
+			for _, ds := range daemonSets.Items {
+				for _, container := range ds.Spec.Template.Spec.Containers {
+					if cni := fromImage(container.Image); cni != nil {
+						cni.Source = fmt.Sprintf("daemonset %s/%s", ds.Namespace, ds.Name)
+						return cni, nil
+					}
+				}
+			}
+		}
+	}
+	cni, err := fromConfDir(cfg.CNIConfDir)
+	if err != nil {
+		return nil, err
+	}
+	if cni == nil {
+		return nil, errors.New("no known CNI detected")
+	}
+	return cni, nil
+}
+
+// fromImage matches the image repository and takes the version from its tag
+func fromImage(image string) *CNI {
+	repository, tag := splitImage(image)
+	for _, p := range plugins {
+		for _, suffix := range p.images {
+			if repository == suffix || strings.HasSuffix(repository, "/"+suffix) {
+				return &CNI{Name: p.name, Version: strings.TrimPrefix(tag, "v")}
+			}
+		}
+	}
+	return nil
+}
+
+// splitImage splits an image reference into repository and tag, dropping the digest
+func splitImage(image string) (repository, tag string) {
+	repository, _, _ = strings.Cut(image, "@")
+	if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+		return repository[:i], repository[i+1:]
+	}
+	return repository, ""
+}
+
+// confFile is the part of the CNI network configuration we care about
+type confFile struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Plugins []struct {
+		Type string `json:"type"`
+	} `json:"plugins"`
+}
+
+// fromConfDir inspects the first CNI config the runtime would load
+func fromConfDir(dir string) (*CNI, error) {
+	if len(dir) == 0 {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CNI config directory: %w", err)
+	}
+	var files []string
+	for _, entry := range entries {
+		switch filepath.Ext(entry.Name()) {
+		case ".conf", ".conflist", ".json":
+			if !entry.IsDir() {
+				files = append(files, entry.Name())
+			}
+		}
+	}
+	slices.Sort(files)
+	for _, file := range files {
+		data, err := os.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CNI config: %w", err)
+		}
+		var conf confFile
+		if err = json.Unmarshal(data, &conf); err != nil {
+			continue
+		}
+		candidates := []string{strings.ToLower(file), conf.Name, conf.Type}
+		for _, p := range conf.Plugins {
+			candidates = append(candidates, p.Type)
+		}
+		for _, p := range plugins {
+			for _, confType := range p.confTypes {
+				for _, candidate := range candidates {
+					if candidate == confType || strings.Contains(candidate, "-"+confType) {
+						return &CNI{Name: p.name, Source: "cni config " + file}, nil
+					}
+				}
+			}
+		}
+		// Only the first valid config is used by the container runtime
+		return nil, nil
+	}
+	return nil, nil
+}
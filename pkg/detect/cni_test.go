@@ -0,0 +1,104 @@
+package detect_test
+
+import (
+	"cni-benchmark/pkg/config"
+	"cni-benchmark/pkg/detect"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestDetect(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Detect")
+}
+
+func daemonSet(namespace, name string, images ...string) *appsv1.DaemonSet {
+	ds := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+	for _, image := range images {
+		ds.Spec.Template.Spec.Containers = append(ds.Spec.Template.Spec.Containers, corev1.Container{Name: name, Image: image})
+	}
+	return ds
+}
+
+var _ = Describe("Plugin", func() {
+	var cfg config.Detect
+
+	BeforeEach(func() {
+		cfg = config.Detect{Namespaces: []string{"kube-system", "kube-flannel"}, CNIConfDir: GinkgoT().TempDir()}
+	})
+
+	It("should detect known CNIs and versions from DaemonSet images", func() {
+		for image, expected := range map[string]detect.CNI{
+			"quay.io/cilium/cilium:v1.16.5@sha256:758ca0793f5995bb938a2fa219dcce63dc0b3fa7fc4ce5cc851125281fb7361d": {Name: "cilium", Version: "1.16.5"},
+			"docker.io/calico/node:v3.29.1":                        {Name: "calico", Version: "3.29.1"},
+			"ghcr.io/flannel-io/flannel:v0.26.2":                   {Name: "flannel", Version: "0.26.2"},
+			"docker.io/flannel/flannel:v0.26.2":                    {Name: "flannel", Version: "0.26.2"},
+			"antrea/antrea-agent-ubuntu:v2.2.0":                    {Name: "antrea", Version: "2.2.0"},
+			"docker.io/cloudnativelabs/kube-router:v2.4.1":         {Name: "kube-router", Version: "2.4.1"},
+			"weaveworks/weave-kube:2.8.1":                          {Name: "weave", Version: "2.8.1"},
+			"registry.k8s.io/kube-proxy:v1.32.0":                   {},
+			"localhost:5000/cilium/cilium":                         {Name: "cilium"},
+			"registry.example.com:5000/mirror/calico/node:v3.28.0": {Name: "calico", Version: "3.28.0"},
+		} {
+			client := fake.NewClientset(
+				daemonSet("kube-system", "kube-proxy", "registry.k8s.io/kube-proxy:v1.32.0"),
+				daemonSet("kube-flannel", "agent", "busybox:latest", image),
+			)
+			cni, err := detect.Plugin(context.Background(), client, cfg)
+			if len(expected.Name) == 0 {
+				Expect(err).To(HaveOccurred(), image)
+				continue
+			}
+			Expect(err).ToNot(HaveOccurred(), image)
+			Expect(cni.Name).To(Equal(expected.Name), image)
+			Expect(cni.Version).To(Equal(expected.Version), image)
+			Expect(cni.Source).To(Equal("daemonset kube-flannel/agent"))
+		}
+	})
+
+	It("should skip namespaces it may not list", func() {
+		client := fake.NewClientset(daemonSet("kube-flannel", "agent", "ghcr.io/flannel-io/flannel:v0.26.2"))
+		client.PrependReactor("list", "daemonsets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if action.GetNamespace() != "kube-system" {
+				return false, nil, nil
+			}
+			return true, nil, apierrors.NewForbidden(appsv1.Resource("daemonsets"), "", errors.New("rbac"))
+		})
+		cni, err := detect.Plugin(context.Background(), client, cfg)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cni).To(Equal(&detect.CNI{Name: "flannel", Version: "0.26.2", Source: "daemonset kube-flannel/agent"}))
+	})
+
+	It("should fall back to the first CNI config", func() {
+		for name, content := range map[string]string{
+			"10-calico.conflist": `{"name": "k8s-pod-network", "plugins": [{"type": "calico"}]}`,
+			"05-cilium.conflist": `{"name": "cilium", "plugins": [{"type": "cilium-cni"}]}`,
+			"00-invalid.conf":    `not json`,
+			"01-readme.txt":      `ignored`,
+		} {
+			Expect(os.WriteFile(filepath.Join(cfg.CNIConfDir, name), []byte(content), 0o600)).To(Succeed())
+		}
+		cni, err := detect.Plugin(context.Background(), fake.NewClientset(), cfg)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cni).To(Equal(&detect.CNI{Name: "cilium", Source: "cni config 05-cilium.conflist"}))
+	})
+
+	It("should fail when nothing is detected", func() {
+		cfg.CNIConfDir = filepath.Join(cfg.CNIConfDir, "missing")
+		_, err := detect.Plugin(context.Background(), fake.NewClientset(), cfg)
+		Expect(err).To(HaveOccurred())
+	})
+})
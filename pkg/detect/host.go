@@ -0,0 +1,175 @@
+package detect
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	config "cni-benchmark/pkg/config"
+)
+
+// Host is a snapshot of the node networking setup
+type Host struct {
+	Sysctls           map[string]string `json:"sysctls"`
+	CongestionControl string            `json:"congestion_control"`
+	Interfaces        []Interface       `json:"interfaces"`
+	CPUModel          string            `json:"cpu_model"`
+	CPUCount          int               `json:"cpu_count"`
+}
+
+// Interface is a network interface as seen in sysfs
+type Interface struct {
+	Name      string          `json:"name"`
+	Driver    string          `json:"driver"`
+	MTU       int             `json:"mtu"`
+	SpeedMbps int             `json:"speed_mbps"`
+	Offloads  map[string]bool `json:"offloads,omitempty"`
+}
+
+// sysctlPatterns select sysctls relevant to the network performance
+var sysctlPatterns = []string{"net/core/*", "net/ipv4/tcp_*"}
+
+// HostSnapshot reads sysctls, interfaces and CPU info from procfs and sysfs
+// mounted at the configured directories. Networking sysctls and interfaces
+// are those of the network namespace of the trees, the node ones need
+// hostNetwork.
+func HostSnapshot(cfg config.Detect) (*Host, error) {
+	host := &Host{Sysctls: map[string]string{}}
+
+	for _, pattern := range sysctlPatterns {
+		paths, err := filepath.Glob(filepath.Join(cfg.ProcDir, "sys", pattern))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			value, ok := readValue(path)
+			if !ok {
+				continue
+			}
+			rel, _ := filepath.Rel(filepath.Join(cfg.ProcDir, "sys"), path)
+			host.Sysctls[strings.ReplaceAll(rel, string(filepath.Separator), ".")] = value
+		}
+	}
+	host.CongestionControl = host.Sysctls["net.ipv4.tcp_congestion_control"]
+
+	netDir := filepath.Join(cfg.SysDir, "class", "net")
+	entries, err := os.ReadDir(netDir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to list interfaces: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.Name() == "lo" {
+			continue
+		}
+		iface := Interface{Name: entry.Name()}
+		dir := filepath.Join(netDir, entry.Name())
+		if value, ok := readValue(filepath.Join(dir, "mtu")); ok {
+			iface.MTU, _ = strconv.Atoi(value)
+		}
+		if value, ok := readValue(filepath.Join(dir, "speed")); ok {
+			// Virtual and down interfaces report -1
+			speed, _ := strconv.Atoi(value)
+			iface.SpeedMbps = max(0, speed)
+		}
+		if driver, err := filepath.EvalSymlinks(filepath.Join(dir, "device", "driver")); err == nil {
+			iface.Driver = filepath.Base(driver)
+		}
+		if local(dir, iface.Name) {
+			iface.Offloads = offloads(iface.Name)
+		}
+		host.Interfaces = append(host.Interfaces, iface)
+	}
+
+	if err = host.readCPUInfo(filepath.Join(cfg.ProcDir, "cpuinfo")); err != nil {
+		return nil, err
+	}
+	return host, nil
+}
+
+// local reports whether the interface of the tree is the one of our network
+// namespace, the only one ethtool reaches. Trees of another namespace, like
+// the host /sys mounted into a pod without hostNetwork, get no offloads.
+func local(dir, name string) bool {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return false
+	}
+	index, _ := readValue(filepath.Join(dir, "ifindex"))
+	address, _ := readValue(filepath.Join(dir, "address"))
+	return index == strconv.Itoa(iface.Index) && address == iface.HardwareAddr.String()
+}
+
+// readCPUInfo takes the first model name and counts processors
+func (h *Host) readCPUInfo(path string) error {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read cpuinfo: %w", err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "processor":
+			h.CPUCount++
+		case "model name", "Model":
+			if len(h.CPUModel) == 0 {
+				h.CPUModel = strings.TrimSpace(value)
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// readValue reads a single value file with whitespace collapsed, ok is false
+// for files that are missing, write-only or unreadable in the current state
+func readValue(path string) (value string, ok bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+	return strings.Join(strings.Fields(string(data)), " "), true
+}
+
+// OSRelease reads the OS name and version from os-release the way the kubelet
+// builds the node OS image, so host and node values are comparable
+func OSRelease(path string) (name, version string, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read os-release: %w", err)
+	}
+	defer file.Close()
+	fields := map[string]string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok || strings.HasPrefix(key, "#") {
+			continue
+		}
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		}
+		fields[key] = strings.Trim(value, "'")
+	}
+	if err = scanner.Err(); err != nil {
+		return "", "", fmt.Errorf("failed to read os-release: %w", err)
+	}
+	image := fields["PRETTY_NAME"]
+	if len(image) == 0 {
+		image = strings.TrimSpace(fields["NAME"] + " " + fields["VERSION"])
+	}
+	name, version = splitOSImage(image)
+	return name, version, nil
+}
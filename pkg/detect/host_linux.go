@@ -0,0 +1,56 @@
+package detect
+
+import (
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// ethtoolCommands are legacy ethtool getters of offload features
+var ethtoolCommands = map[string]uint32{
+	"rx-checksum":                  unix.ETHTOOL_GRXCSUM,
+	"tx-checksum":                  unix.ETHTOOL_GTXCSUM,
+	"scatter-gather":               unix.ETHTOOL_GSG,
+	"tcp-segmentation-offload":     unix.ETHTOOL_GTSO,
+	"generic-segmentation-offload": unix.ETHTOOL_GGSO,
+	"generic-receive-offload":      unix.ETHTOOL_GGRO,
+}
+
+type ethtoolValue struct {
+	cmd  uint32
+	data uint32
+}
+
+type ethtoolRequest struct {
+	name [unix.IFNAMSIZ]byte
+	data unsafe.Pointer
+	_    [24 - unsafe.Sizeof(uintptr(0))]byte
+}
+
+// offloads queries offload features through the SIOCETHTOOL ioctl of our
+// network namespace, nil is returned when the interface is not found there
+func offloads(name string) map[string]bool {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil
+	}
+	defer unix.Close(fd)
+
+	features := map[string]bool{}
+	for feature, cmd := range ethtoolCommands {
+		value := ethtoolValue{cmd: cmd}
+		request := ethtoolRequest{data: unsafe.Pointer(&value)}
+		copy(request.name[:unix.IFNAMSIZ-1], name)
+		_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), unix.SIOCETHTOOL, uintptr(unsafe.Pointer(&request)))
+		runtime.KeepAlive(&value)
+		if errno != 0 {
+			continue
+		}
+		features[feature] = value.data != 0
+	}
+	if len(features) == 0 {
+		return nil
+	}
+	return features
+}
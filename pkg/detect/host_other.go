@@ -0,0 +1,8 @@
+//go:build !linux
+
+package detect
+
+// offloads is only supported on Linux
+func offloads(string) map[string]bool {
+	return nil
+}
@@ -0,0 +1,93 @@
+package detect_test
+
+import (
+	"cni-benchmark/pkg/config"
+	"cni-benchmark/pkg/detect"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("HostSnapshot", func() {
+	var cfg config.Detect
+
+	write := func(path, content string) {
+		Expect(os.MkdirAll(filepath.Dir(path), 0o755)).To(Succeed())
+		Expect(os.WriteFile(path, []byte(content), 0o600)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		root := GinkgoT().TempDir()
+		cfg = config.Detect{ProcDir: filepath.Join(root, "proc"), SysDir: filepath.Join(root, "sys")}
+		write(filepath.Join(cfg.ProcDir, "sys/net/core/somaxconn"), "4096\n")
+		write(filepath.Join(cfg.ProcDir, "sys/net/core/rmem_max"), "212992\n")
+		write(filepath.Join(cfg.ProcDir, "sys/net/ipv4/tcp_congestion_control"), "bbr\n")
+		write(filepath.Join(cfg.ProcDir, "sys/net/ipv4/tcp_rmem"), "4096\t131072\t6291456\n")
+		write(filepath.Join(cfg.ProcDir, "sys/net/ipv4/ip_forward"), "1\n")
+		write(filepath.Join(cfg.ProcDir, "cpuinfo"), "processor\t: 0\nmodel name\t: AMD EPYC 7B13\n\nprocessor\t: 1\nmodel name\t: AMD EPYC 7B13\n")
+
+		write(filepath.Join(cfg.SysDir, "bus/pci/drivers/virtio_net/bind"), "")
+		write(filepath.Join(cfg.SysDir, "class/net/eth0/mtu"), "9000\n")
+		write(filepath.Join(cfg.SysDir, "class/net/eth0/speed"), "25000\n")
+		Expect(os.MkdirAll(filepath.Join(cfg.SysDir, "class/net/eth0/device"), 0o755)).To(Succeed())
+		Expect(os.Symlink(
+			filepath.Join(cfg.SysDir, "bus/pci/drivers/virtio_net"),
+			filepath.Join(cfg.SysDir, "class/net/eth0/device/driver"),
+		)).To(Succeed())
+		write(filepath.Join(cfg.SysDir, "class/net/cni0/mtu"), "1450\n")
+		write(filepath.Join(cfg.SysDir, "class/net/cni0/speed"), "-1\n")
+		write(filepath.Join(cfg.SysDir, "class/net/lo/mtu"), "65536\n")
+	})
+
+	It("should read sysctls, interfaces and CPU info", func() {
+		host, err := detect.HostSnapshot(cfg)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(host.Sysctls).To(Equal(map[string]string{
+			"net.core.somaxconn":              "4096",
+			"net.core.rmem_max":               "212992",
+			"net.ipv4.tcp_congestion_control": "bbr",
+			"net.ipv4.tcp_rmem":               "4096 131072 6291456",
+		}))
+		Expect(host.CongestionControl).To(Equal("bbr"))
+		Expect(host.CPUModel).To(Equal("AMD EPYC 7B13"))
+		Expect(host.CPUCount).To(Equal(2))
+		Expect(host.Interfaces).To(HaveLen(2))
+		Expect(host.Interfaces[0]).To(And(
+			HaveField("Name", "cni0"), HaveField("MTU", 1450), HaveField("SpeedMbps", 0), HaveField("Driver", ""),
+		))
+		Expect(host.Interfaces[1]).To(And(
+			HaveField("Name", "eth0"), HaveField("MTU", 9000), HaveField("SpeedMbps", 25000), HaveField("Driver", "virtio_net"),
+		))
+		// The tree is not our network namespace, ethtool is not queried
+		Expect(host.Interfaces).To(HaveEach(HaveField("Offloads", BeNil())))
+	})
+
+	It("should return an empty snapshot for missing trees", func() {
+		cfg = config.Detect{ProcDir: "/nonexistent/proc", SysDir: "/nonexistent/sys"}
+		host, err := detect.HostSnapshot(cfg)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(host.Sysctls).To(BeEmpty())
+		Expect(host.Interfaces).To(BeEmpty())
+		Expect(host.CPUCount).To(BeZero())
+	})
+
+	It("should read the OS from os-release", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "os-release")
+		write(path, "NAME=\"Ubuntu\"\nVERSION=\"24.04.1 LTS (Noble Numbat)\"\nPRETTY_NAME=\"Ubuntu 24.04.1 LTS\"\n")
+		name, version, err := detect.OSRelease(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(name).To(Equal("Ubuntu"))
+		Expect(version).To(Equal("24.04.1 LTS"))
+
+		write(path, "# minimal\nNAME=Alpine\nVERSION=3.21\n")
+		name, version, err = detect.OSRelease(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(name).To(Equal("Alpine"))
+		Expect(version).To(Equal("3.21"))
+
+		_, _, err = detect.OSRelease("/nonexistent/os-release")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,157 @@
+package dns
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	config "cni-benchmark/pkg/config"
+
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// resolvConf is the file the pod's resolver is read from
+var resolvConf = "/etc/resolv.conf"
+
+// Run resolves configured names repeatedly for the configured duration
+func Run(ctx context.Context, cfg *config.Config) (report *Report, err error) {
+	log := logf.FromContext(ctx)
+	if len(cfg.DNS.Names) == 0 {
+		return nil, errors.New("no DNS names to resolve")
+	}
+	server, err := resolverAddress(cfg.DNS.Server)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find DNS resolver: %w", err)
+	}
+	log.Info("starting DNS benchmark", "server", server, "names", cfg.DNS.Names)
+
+	report = &Report{Start: time.Now(), Server: server}
+	deadline := report.Start.Add(time.Duration(cfg.Duration) * time.Second)
+	ticker := time.NewTicker(cfg.DNS.Interval)
+	defer ticker.Stop()
+
+	for {
+		for _, name := range cfg.DNS.Names {
+			report.Samples = append(report.Samples, probe(ctx, server, name, cfg.DNS.Timeout, report.Start))
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case now := <-ticker.C:
+			if !now.Before(deadline) {
+				log.Info("DNS benchmark finished", "samples", len(report.Samples))
+				return report, nil
+			}
+		}
+	}
+}
+
+// probe runs and classifies a single query
+func probe(ctx context.Context, server, name string, timeout time.Duration, start time.Time) Sample {
+	sample := Sample{Name: name, Offset: time.Since(start)}
+	began := time.Now()
+	rcode, err := Query(ctx, server, name, timeout)
+	sample.Latency = time.Since(began)
+	var netErr net.Error
+	switch {
+	case errors.As(err, &netErr) && netErr.Timeout():
+		sample.Result = ResultTimeout
+	case err != nil:
+		sample.Result = ResultFailure
+	case rcode == dnsmessage.RCodeServerFailure:
+		sample.Result = ResultServFail
+	case rcode == dnsmessage.RCodeSuccess, rcode == dnsmessage.RCodeNameError:
+		sample.Result = ResultSuccess
+	default:
+		sample.Result = ResultFailure
+	}
+	return sample
+}
+
+// Query sends a single A query over UDP and returns the response code
+func Query(ctx context.Context, server, name string, timeout time.Duration) (rcode dnsmessage.RCode, err error) {
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return 0, fmt.Errorf("invalid DNS name %s: %w", name, err)
+	}
+	id := uint16(time.Now().UnixNano())
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: qname, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := msg.Pack()
+	if err != nil {
+		return 0, fmt.Errorf("failed to pack DNS query: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", server)
+	if err != nil {
+		return 0, fmt.Errorf("failed to dial DNS server: %w", err)
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	if err = conn.SetDeadline(deadline); err != nil {
+		return 0, err
+	}
+	if _, err = conn.Write(packed); err != nil {
+		return 0, fmt.Errorf("failed to send DNS query: %w", err)
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read DNS response: %w", err)
+		}
+		var parser dnsmessage.Parser
+		header, err := parser.Start(buf[:n])
+		if err != nil || header.ID != id || !header.Response {
+			// Ignore stray or malformed packets until the deadline
+			continue
+		}
+		return header.RCode, nil
+	}
+}
+
+// resolverAddress returns host:port of the explicit server or the first
+// nameserver of the pod's resolver
+func resolverAddress(server string) (string, error) {
+	if len(server) == 0 {
+		file, err := os.Open(resolvConf)
+		if err != nil {
+			return "", err
+		}
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) >= 2 && fields[0] == "nameserver" {
+				server = fields[1]
+				break
+			}
+		}
+		if err = scanner.Err(); err != nil {
+			return "", err
+		}
+		if len(server) == 0 {
+			return "", fmt.Errorf("no nameserver in %s", resolvConf)
+		}
+	}
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server, nil
+	}
+	return net.JoinHostPort(strings.Trim(server, "[]"), "53"), nil
+}
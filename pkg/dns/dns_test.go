@@ -0,0 +1,154 @@
+package dns_test
+
+import (
+	"cni-benchmark/pkg/config"
+	"cni-benchmark/pkg/detect"
+	"cni-benchmark/pkg/dns"
+	"cni-benchmark/pkg/iperf3"
+	"cni-benchmark/test/utils"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/dns/dnsmessage"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestDNS(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "DNS")
+}
+
+var _ = Describe("DNS", func() {
+	var server *utils.DNSServer
+
+	BeforeEach(func() {
+		var err error
+		server, err = utils.StartDNSServer(map[string]string{
+			"kubernetes.default.svc.cluster.local": "10.96.0.1",
+			"broken.example.com":                   utils.DNSServFail,
+			"silent.example.com":                   utils.DNSDrop,
+		})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(server.Close()).To(Succeed())
+	})
+
+	Context("Query", func() {
+		It("should return response codes", func() {
+			for name, expected := range map[string]dnsmessage.RCode{
+				"kubernetes.default.svc.cluster.local":  dnsmessage.RCodeSuccess,
+				"kubernetes.default.svc.cluster.local.": dnsmessage.RCodeSuccess,
+				"broken.example.com":                    dnsmessage.RCodeServerFailure,
+				"missing.example.com":                   dnsmessage.RCodeNameError,
+			} {
+				rcode, err := dns.Query(context.Background(), server.Addr(), name, time.Second)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(rcode).To(Equal(expected))
+			}
+		})
+
+		It("should time out when there is no answer", func() {
+			_, err := dns.Query(context.Background(), server.Addr(), "silent.example.com", 100*time.Millisecond)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Run", func() {
+		It("should collect samples and aggregate intervals", func() {
+			cfg := &config.Config{
+				Duration: 1,
+				DNS: config.DNS{
+					Names:    []string{"kubernetes.default.svc.cluster.local", "broken.example.com", "silent.example.com"},
+					Server:   server.Addr(),
+					Interval: 100 * time.Millisecond,
+					Timeout:  50 * time.Millisecond,
+				},
+			}
+			report, err := dns.Run(context.Background(), cfg)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(report.Samples).ToNot(BeEmpty())
+
+			totals := map[string]*dns.Interval{}
+			for _, interval := range report.Intervals() {
+				Expect(interval.End).To(Equal(interval.Start + 1))
+				if totals[interval.Name] == nil {
+					totals[interval.Name] = &dns.Interval{}
+				}
+				totals[interval.Name].Queries += interval.Queries
+				totals[interval.Name].Timeouts += interval.Timeouts
+				totals[interval.Name].ServFails += interval.ServFails
+				if interval.Name == "kubernetes.default.svc.cluster.local" {
+					Expect(interval.LatencyMax).To(BeNumerically(">=", interval.LatencyP50))
+				}
+			}
+			Expect(totals).To(HaveLen(3))
+			Expect(totals["silent.example.com"].Timeouts).To(Equal(totals["silent.example.com"].Queries))
+			Expect(totals["broken.example.com"].ServFails).To(Equal(totals["broken.example.com"].Queries))
+			Expect(totals["kubernetes.default.svc.cluster.local"].Timeouts).To(BeZero())
+		})
+	})
+
+	Context("Intervals", func() {
+		It("should compute nearest-rank percentiles", func() {
+			report := &dns.Report{}
+			for i := 1; i <= 100; i++ {
+				report.Samples = append(report.Samples, dns.Sample{
+					Name: "a", Offset: 1500 * time.Millisecond, Latency: time.Duration(i) * time.Millisecond,
+				})
+			}
+			report.Samples = append(report.Samples, dns.Sample{Name: "a", Offset: 1500 * time.Millisecond, Result: dns.ResultTimeout})
+			intervals := report.Intervals()
+			Expect(intervals).To(HaveLen(1))
+			Expect(intervals[0].Start).To(Equal(1.0))
+			Expect(intervals[0].Queries).To(Equal(uint64(101)))
+			Expect(intervals[0].Timeouts).To(Equal(uint64(1)))
+			Expect(intervals[0].LatencyP50).To(Equal(50.0))
+			Expect(intervals[0].LatencyP90).To(Equal(90.0))
+			Expect(intervals[0].LatencyP99).To(Equal(99.0))
+			Expect(intervals[0].LatencyMax).To(Equal(100.0))
+		})
+	})
+
+	Context("Store", func() {
+		It("should save intervals to the database", func() {
+			dsn := "file:" + filepath.Join(GinkgoT().TempDir(), "dns-store.db")
+			cfg := &config.Config{DatabaseDialector: sqlite.Open(dsn)}
+			report := &dns.Report{Start: time.Now(), Samples: []dns.Sample{
+				{Name: "a", Latency: time.Millisecond},
+				{Name: "a", Offset: time.Second, Result: dns.ResultServFail},
+			}}
+			info := (&iperf3.Info{TestCase: "dns", Labels: map[string]string{"encryption": "wireguard", "mtu": "9000"},
+				Host: &detect.Host{CongestionControl: "bbr"}}).NewRun()
+			Expect(dns.Store(context.Background(), cfg, report, info)).To(Succeed())
+			Expect(dns.Store(context.Background(), cfg, report, (&iperf3.Info{TestCase: "plain"}).NewRun())).To(Succeed())
+
+			db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+			Expect(err).ToNot(HaveOccurred())
+			var metrics []dns.Metric
+			Expect(db.Order("interval_start").Find(&metrics).Error).To(Succeed())
+			Expect(metrics).To(HaveLen(4))
+
+			labels := map[string]string{"encryption": "wireguard", "mtu": "9000"}
+			Expect(db.Scopes(iperf3.WithLabels(labels)).Order("interval_start").Find(&metrics).Error).To(Succeed())
+			Expect(metrics).To(HaveLen(2))
+			Expect(metrics[0].TestCase).To(Equal("dns"))
+			Expect(metrics[0].RunID).ToNot(BeEmpty())
+			Expect(metrics[1].ServFails).To(Equal(uint64(1)))
+
+			labels["mtu"] = "1500"
+			Expect(db.Scopes(iperf3.WithLabels(labels)).Find(&metrics).Error).To(Succeed())
+			Expect(metrics).To(BeEmpty())
+
+			var hosts []iperf3.Host
+			Expect(db.Find(&hosts).Error).To(Succeed())
+			Expect(hosts).To(ConsistOf(iperf3.Host{RunID: info.RunID, Snapshot: info.Host}))
+		})
+	})
+})
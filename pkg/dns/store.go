@@ -0,0 +1,83 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+
+	config "cni-benchmark/pkg/config"
+	"cni-benchmark/pkg/iperf3"
+	"cni-benchmark/pkg/metrics"
+	"cni-benchmark/pkg/tracing"
+
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Store pushes DNS interval metrics to the database
+func Store(ctx context.Context, cfg *config.Config, report *Report, info *iperf3.Info) (err error) {
+	log := logf.FromContext(ctx)
+	if cfg == nil {
+		return errors.New("configuration is required")
+	}
+	ctx, span := tracing.Start(ctx, "store", trace.WithAttributes(info.Attributes()...))
+	defer func() { tracing.End(span, err) }()
+
+	// The connection is reused across attempts, credentials are still
+	// resolved on every attempt to pick up rotated ones
+	conn := cfg.Connect()
+	defer conn.Close()
+	operation := func() error {
+		db, err := conn.DB(ctx)
+		if err != nil {
+			return err
+		}
+		log.V(1).Info("using the database", "type", db.Name())
+		if err = db.AutoMigrate(&Metric{}, &iperf3.Label{}, &iperf3.Host{}); err != nil {
+			return fmt.Errorf("failed to migrate database: %w", err)
+		}
+		return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(BuildMetrics(cfg, report, info)).Error; err != nil {
+				return fmt.Errorf("failed to create DNS metrics: %w", err)
+			}
+			if err := iperf3.StoreLabels(tx, info); err != nil {
+				return err
+			}
+			return iperf3.StoreHost(tx, info)
+		})
+	}
+
+	return metrics.RetryStore(ctx, "dns", operation)
+}
+
+// BuildMetrics turns report intervals into rows carrying the run info
+func BuildMetrics(cfg *config.Config, report *Report, info *iperf3.Info) (metrics []*Metric) {
+	// If AlignTime is true, set baseTime to 12:00 of the current day
+	baseTime := report.Start
+	if cfg.AlignTime {
+		now := time.Now()
+		baseTime = time.Date(now.Year(), now.Month(), now.Day(), 12, 0, 0, 0, now.Location())
+	}
+	info.Iperf3Protocol = "dns"
+	for _, interval := range report.Intervals() {
+		metrics = append(metrics, &Metric{
+			Timestamp:     baseTime.Add(time.Duration(interval.Start) * time.Second),
+			Info:          *info,
+			Name:          interval.Name,
+			Queries:       interval.Queries,
+			Timeouts:      interval.Timeouts,
+			ServFails:     interval.ServFails,
+			Failures:      interval.Failures,
+			LatencyP50Ms:  interval.LatencyP50,
+			LatencyP90Ms:  interval.LatencyP90,
+			LatencyP99Ms:  interval.LatencyP99,
+			LatencyMaxMs:  interval.LatencyMax,
+			IntervalStart: interval.Start,
+			IntervalEnd:   interval.End,
+		})
+	}
+	return
+}
@@ -0,0 +1,136 @@
+package dns
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"time"
+
+	"gorm.io/gorm/schema"
+
+	"cni-benchmark/pkg/iperf3"
+)
+
+// Result is an outcome of a single DNS query
+type Result uint8
+
+const (
+	ResultSuccess Result = iota
+	ResultTimeout
+	ResultServFail
+	ResultFailure
+)
+
+// Sample is a single DNS query measurement
+type Sample struct {
+	Name    string
+	Offset  time.Duration
+	Latency time.Duration
+	Result  Result
+}
+
+// Report holds all samples collected during the benchmark
+type Report struct {
+	Start   time.Time
+	Server  string
+	Samples []Sample
+}
+
+// Interval aggregates samples of a single name within one second
+type Interval struct {
+	Name      string
+	Start     float64
+	End       float64
+	Queries   uint64
+	Timeouts  uint64
+	ServFails uint64
+	Failures  uint64
+	// Latencies of answered queries in milliseconds
+	LatencyP50 float64
+	LatencyP90 float64
+	LatencyP99 float64
+	LatencyMax float64
+}
+
+// Intervals groups samples into one second buckets per name, ordered by time
+func (r *Report) Intervals() (intervals []Interval) {
+	type key struct {
+		second int64
+		name   string
+	}
+	latencies := map[key][]float64{}
+	index := map[key]int{}
+	for _, sample := range r.Samples {
+		k := key{int64(sample.Offset / time.Second), sample.Name}
+		i, ok := index[k]
+		if !ok {
+			i = len(intervals)
+			index[k] = i
+			intervals = append(intervals, Interval{
+				Name:  sample.Name,
+				Start: float64(k.second),
+				End:   float64(k.second + 1),
+			})
+		}
+		interval := &intervals[i]
+		interval.Queries++
+		switch sample.Result {
+		case ResultTimeout:
+			interval.Timeouts++
+			continue
+		case ResultServFail:
+			interval.ServFails++
+		case ResultFailure:
+			interval.Failures++
+			continue
+		}
+		latencies[k] = append(latencies[k], float64(sample.Latency)/float64(time.Millisecond))
+	}
+	for k, i := range index {
+		values := latencies[k]
+		slices.Sort(values)
+		intervals[i].LatencyP50 = percentile(values, 50)
+		intervals[i].LatencyP90 = percentile(values, 90)
+		intervals[i].LatencyP99 = percentile(values, 99)
+		intervals[i].LatencyMax = percentile(values, 100)
+	}
+	slices.SortStableFunc(intervals, func(a, b Interval) int {
+		return cmp.Compare(a.Start, b.Start)
+	})
+	return
+}
+
+// percentile returns nearest-rank percentile of sorted values
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
+
+// Metric represents interval metrics of DNS resolution
+type Metric struct {
+	ID        uint      `gorm:"primaryKey"`
+	Timestamp time.Time `gorm:"index;not null"`
+	iperf3.Info
+
+	// Metrics
+	Name          string  `gorm:"type:varchar(255);index;not null"`
+	Queries       uint64  `gorm:"not null"`
+	Timeouts      uint64  `gorm:"not null"`
+	ServFails     uint64  `gorm:"not null"`
+	Failures      uint64  `gorm:"not null"`
+	LatencyP50Ms  float64 `gorm:"not null;check:latency_p50_ms >= 0"`
+	LatencyP90Ms  float64 `gorm:"not null;check:latency_p90_ms >= 0"`
+	LatencyP99Ms  float64 `gorm:"not null;check:latency_p99_ms >= 0"`
+	LatencyMaxMs  float64 `gorm:"not null;check:latency_max_ms >= 0"`
+	IntervalStart float64 `gorm:"not null;check:interval_start >= 0"`
+	IntervalEnd   float64 `gorm:"not null;check:interval_end >= interval_start"`
+}
+
+// TableName keeps DNS metrics apart from iperf3 ones, the configured table
+// prefix still applies
+func (Metric) TableName(namer schema.Namer) string {
+	return namer.TableName("DNSMetric")
+}
@@ -1,66 +1,278 @@
 package iperf3
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
+	"maps"
 	"runtime"
+	"slices"
+	"strings"
 
 	"github.com/docker/docker/pkg/parsers/kernel"
+	"go.opentelemetry.io/otel/attribute"
 
 	config "cni-benchmark/pkg/config"
+	"cni-benchmark/pkg/detect"
 
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/ptr"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
 )
 
-func (info *Info) Build(cfg *config.Config) (err error) {
+// Build gathers information about the environment from ConfigMaps and
+// fills the rest from the live cluster. In standalone mode the values come
+// from the configuration and the host. Values that are neither set nor
+// detected are left empty.
+func (info *Info) Build(ctx context.Context, cfg *config.Config) (err error) {
+	log := logf.FromContext(ctx)
+	client := cfg.K8sClient
+	if client == nil && !cfg.Standalone {
+		return errors.New("kubernetes client is required to gather information")
+	}
+
 	// Get extra info
 	kv, err := kernel.GetKernelVersion()
 	if err != nil {
 		return fmt.Errorf("failed to get kernel info: %w", err)
 	}
 
-	client, err := config.BuildKubernetesClient()
-	if err != nil {
-		return fmt.Errorf("failed to make a kubernetes client: %w", err)
-	}
-
-	// Fetch ConfigMaps
-	cm := map[string]*corev1.ConfigMap{
-		"os-info":  nil,
-		"k8s-info": nil,
-		"cni-info": nil,
+	// Values set explicitly are overrides of detected ones
+	source := cfg.Info
+	names := map[string]string{"os": source.OSConfigMap, "k8s": source.K8sConfigMap, "cni": source.CNIConfigMap}
+	if len(source.ConfigMap) > 0 {
+		names = map[string]string{"os": source.ConfigMap, "k8s": source.ConfigMap, "cni": source.ConfigMap}
 	}
-	for name := range cm {
-		cm[name], err = client.CoreV1().ConfigMaps("default").Get(context.TODO(), name, metav1.GetOptions{})
-		if err != nil {
-			return err
+	lookup := func(_, key string) string { return source.Values[strings.ToLower(key)] }
+	if !cfg.Standalone {
+		cm := map[string]*corev1.ConfigMap{}
+		for _, name := range names {
+			if _, ok := cm[name]; ok {
+				continue
+			}
+			cm[name], err = client.CoreV1().ConfigMaps(source.Namespace).Get(ctx, name, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				log.Info("info configmap is not found, values will be detected", "namespace", source.Namespace, "name", name)
+				cm[name], err = &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name}}, nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to get info configmap %s: %w", name, err)
+			}
 		}
+		lookup = func(group, key string) string { return cm[names[group]].Data[source.KeyPrefix+key] }
 	}
 
 	// Fill fields
-	var ok bool
 	info.TestCase = cfg.TestCase
 	info.OsKernelVersion = kv.String()
 	info.OsKernelArch = runtime.GOARCH
+	if info.Host, err = detect.HostSnapshot(cfg.Detect); err != nil {
+		return fmt.Errorf("failed to take host snapshot: %w", err)
+	}
 	type ref struct {
-		ConfigMap *corev1.ConfigMap
-		Variable  *string
+		Group    string
+		Variable *string
 	}
 	m := map[string]ref{
-		"OS_NAME":              {cm["os-info"], &info.OsName},
-		"OS_VERSION":           {cm["os-info"], &info.OsVersion},
-		"K8S_PROVIDER":         {cm["k8s-info"], &info.K8sProvider},
-		"K8S_PROVIDER_VERSION": {cm["k8s-info"], &info.K8sProviderVersion},
-		"K8S_VERSION":          {cm["k8s-info"], &info.K8sVersion},
-		"CNI_NAME":             {cm["cni-info"], &info.CNIName},
-		"CNI_VERSION":          {cm["cni-info"], &info.CNIVersion},
-		"CNI_DESCRIPTION":      {cm["cni-info"], &info.CNIDescription},
+		"OS_NAME":               {"os", &info.OsName},
+		"OS_VERSION":            {"os", &info.OsVersion},
+		"K8S_PROVIDER":          {"k8s", &info.K8sProvider},
+		"K8S_PROVIDER_VERSION":  {"k8s", &info.K8sProviderVersion},
+		"K8S_VERSION":           {"k8s", &info.K8sVersion},
+		"K8S_CONTAINER_RUNTIME": {"k8s", &info.K8sContainerRuntime},
+		"K8S_KUBELET_VERSION":   {"k8s", &info.K8sKubeletVersion},
+		"CNI_NAME":              {"cni", &info.CNIName},
+		"CNI_VERSION":           {"cni", &info.CNIVersion},
+		"CNI_DESCRIPTION":       {"cni", &info.CNIDescription},
+	}
+	for field, r := range m {
+		*r.Variable = lookup(r.Group, field)
+	}
+
+	// Explicit labels override the ones from the ConfigMap
+	info.Labels = map[string]string{}
+	if len(cfg.LabelsConfigMap) > 0 {
+		namespace, name, err := cfg.LabelsConfigMapRef()
+		if err != nil {
+			return err
+		}
+		labels, err := client.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get labels configmap: %w", err)
+		}
+		maps.Copy(info.Labels, labels.Data)
+	}
+	maps.Copy(info.Labels, cfg.ExtraLabels)
+
+	// Fill the rest from the live cluster or the host
+	if cfg.Standalone {
+		info.detectLocal(ctx, cfg)
+	} else {
+		info.detect(ctx, client, cfg)
 	}
 	for field, r := range m {
-		if *r.Variable, ok = r.ConfigMap.Data[field]; !ok {
-			return fmt.Errorf("could not find %s in %s", field, r.ConfigMap.Name)
+		if len(*r.Variable) == 0 {
+			from := names[r.Group]
+			if cfg.Standalone {
+				from = "configuration"
+			}
+			log.Info("information is neither set nor detected, leaving it empty",
+				"key", source.KeyPrefix+field, "source", from)
+		}
+	}
+	return
+}
+
+// detectLocal fills empty fields from the host in standalone mode. There is
+// no CNI on the host path, so the CNI name defaults to none to keep host
+// baselines apart from CNI results.
+func (info *Info) detectLocal(ctx context.Context, cfg *config.Config) {
+	if len(info.OsName) == 0 || len(info.OsVersion) == 0 {
+		name, version, err := detect.OSRelease(cfg.Detect.OSRelease)
+		if err != nil {
+			logf.FromContext(ctx).Error(err, "failed to detect OS")
+		}
+		pair(&info.OsName, &info.OsVersion, name, version)
+	}
+	info.CNIName = cmp.Or(info.CNIName, "none")
+}
+
+// detect fills empty fields from the API server, the client node status
+// and the CNI detection. Failures are logged, the fields are left empty.
+func (info *Info) detect(ctx context.Context, client kubernetes.Interface, cfg *config.Config) {
+	log := logf.FromContext(ctx)
+	if len(info.K8sVersion) == 0 {
+		version, err := detect.KubernetesVersion(client)
+		if err != nil {
+			log.Error(err, "failed to detect kubernetes version")
+		}
+		info.K8sVersion = version
+	}
+
+	if len(info.OsName) == 0 || len(info.OsVersion) == 0 ||
+		len(info.K8sContainerRuntime) == 0 || len(info.K8sKubeletVersion) == 0 {
+		nodeName, err := clientNodeName(ctx, client, cfg)
+		if err != nil {
+			log.Error(err, "failed to find the client node")
 		}
+		if len(nodeName) > 0 {
+			node, err := detect.NodeInfo(ctx, client, nodeName)
+			if err != nil {
+				log.Error(err, "failed to detect node information")
+			} else {
+				pair(&info.OsName, &info.OsVersion, node.OSName, node.OSVersion)
+				info.K8sContainerRuntime = cmp.Or(info.K8sContainerRuntime, node.ContainerRuntime)
+				info.K8sKubeletVersion = cmp.Or(info.K8sKubeletVersion, node.KubeletVersion)
+			}
+		}
+	}
+
+	if len(info.CNIName) == 0 || len(info.CNIVersion) == 0 {
+		cni, err := detect.Plugin(ctx, client, cfg.Detect)
+		if err != nil {
+			log.Error(err, "failed to detect CNI")
+			return
+		}
+		pair(&info.CNIName, &info.CNIVersion, cni.Name, cni.Version)
+	}
+}
+
+// pair fills a name and its version from detected ones so that both come
+// from the same source. Without a name the detected pair is taken, a set
+// name only takes the detected version when the names match.
+func pair(name, version *string, detectedName, detectedVersion string) {
+	switch {
+	case len(*name) == 0:
+		*name, *version = detectedName, detectedVersion
+	case len(*version) == 0 && strings.EqualFold(*name, detectedName):
+		*version = detectedVersion
+	}
+}
+
+// clientNodeName returns the client node from the downward API or the client pod
+func clientNodeName(ctx context.Context, client kubernetes.Interface, cfg *config.Config) (string, error) {
+	if len(cfg.Pod.NodeName) > 0 || len(cfg.Pod.Name) == 0 || len(cfg.Pod.Namespace) == 0 {
+		return cfg.Pod.NodeName, nil
+	}
+	pod, err := client.CoreV1().Pods(cfg.Pod.Namespace).Get(ctx, cfg.Pod.Name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get client pod: %w", err)
+	}
+	return pod.Spec.NodeName, nil
+}
+
+// Locate fills node placement of the client and server pods and derives
+// their topology, which stays unset unless both sides are known. The server
+// node must be set beforehand.
+func (info *Info) Locate(ctx context.Context, client kubernetes.Interface, cfg *config.Config) (err error) {
+	if info.ClientNodeName, err = clientNodeName(ctx, client, cfg); err != nil {
+		return err
+	}
+
+	if info.ClientZone, info.ClientInstanceType, err = nodeTopology(ctx, client, info.ClientNodeName); err != nil {
+		return err
+	}
+	if info.ServerZone, info.ServerInstanceType, err = nodeTopology(ctx, client, info.ServerNodeName); err != nil {
+		return err
+	}
+	// Unknown placement is left empty rather than looking like a verified one
+	info.SameNode, info.CrossZone = nil, nil
+	if len(info.ClientNodeName) > 0 && len(info.ServerNodeName) > 0 {
+		info.SameNode = ptr.To(info.ClientNodeName == info.ServerNodeName)
+	}
+	if len(info.ClientZone) > 0 && len(info.ServerZone) > 0 {
+		info.CrossZone = ptr.To(info.ClientZone != info.ServerZone)
+	}
+	return
+}
+
+// nodeTopology reads well-known zone and instance type labels of the node
+func nodeTopology(ctx context.Context, client kubernetes.Interface, name string) (zone, instanceType string, err error) {
+	if len(name) == 0 {
+		return
+	}
+	node, err := client.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get node %s: %w", name, err)
+	}
+	return node.Labels[corev1.LabelTopologyZone], node.Labels[corev1.LabelInstanceTypeStable], nil
+}
+
+// NewRun returns a copy of the info with a fresh run identifier
+func (info *Info) NewRun() *Info {
+	run := *info
+	run.RunID = string(uuid.NewUUID())
+	return &run
+}
+
+// Attributes describes the run for tracing, empty values are skipped
+func (info *Info) Attributes() (attributes []attribute.KeyValue) {
+	for key, value := range map[string]string{
+		"benchmark.test_case":   info.TestCase,
+		"benchmark.run_id":      info.RunID,
+		"benchmark.target_type": info.TargetType,
+		"benchmark.ip_family":   info.IPFamily,
+		"benchmark.client_node": info.ClientNodeName,
+		"benchmark.server_node": info.ServerNodeName,
+		"cni.name":              info.CNIName,
+		"cni.version":           info.CNIVersion,
+		"k8s.provider":          info.K8sProvider,
+		"k8s.version":           info.K8sVersion,
+		"os.name":               info.OsName,
+		"os.kernel_version":     info.OsKernelVersion,
+	} {
+		if len(value) > 0 {
+			attributes = append(attributes, attribute.String(key, value))
+		}
+	}
+	for key, value := range info.Labels {
+		attributes = append(attributes, attribute.String("benchmark.label."+key, value))
 	}
+	slices.SortFunc(attributes, func(a, b attribute.KeyValue) int { return cmp.Compare(a.Key, b.Key) })
 	return
 }
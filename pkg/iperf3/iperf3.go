@@ -3,16 +3,17 @@ package iperf3
 import (
 	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
 	"os/exec"
+	"strconv"
 	"time"
 
 	config "cni-benchmark/pkg/config"
+	"cni-benchmark/pkg/metrics"
 
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 )
@@ -26,17 +27,26 @@ func WaitForServer(ctx context.Context, cfg *config.Config) error {
 	if len(cfg.Server) == 0 {
 		return errors.New("server must be set in client mode")
 	}
-	address := fmt.Sprintf("%s:%d", cfg.Server, cfg.Port)
+	address := net.JoinHostPort(string(cfg.Server), strconv.Itoa(int(cfg.Port)))
+	network := "tcp"
+	switch cfg.IPFamily {
+	case config.IPFamilyIPv4:
+		network = "tcp4"
+	case config.IPFamilyIPv6:
+		network = "tcp6"
+	}
 	log.Info("waiting for server", "address", address)
+	start := time.Now()
 
 	for {
 		select {
 		case <-ctx.Done():
 			return errors.New("timeout waiting for server")
 		default:
-			conn, err := net.DialTimeout("tcp", address, 5*time.Second)
+			conn, err := net.DialTimeout(network, address, 5*time.Second)
 			if err == nil {
 				conn.Close()
+				metrics.WaitForServer.Observe(time.Since(start).Seconds())
 				log.Info("server is reachable")
 				return nil
 			}
@@ -46,17 +56,33 @@ func WaitForServer(ctx context.Context, cfg *config.Config) error {
 	}
 }
 
-// Run iperf3 and get JSON output
-func Run(_ context.Context, cfg *config.Config) (report *Report, err error) {
-	if err = WaitForServer(context.Background(), cfg); err != nil {
-		return nil, fmt.Errorf("failed waiting for server: %w", err)
+// Run iperf3 and get JSON output. In client mode the caller waits for the
+// server with WaitForServer first.
+func Run(ctx context.Context, cfg *config.Config) (report *Report, err error) {
+	if _, stream := cfg.Args["--json-stream"]; stream {
+		if version, ok := streamSupported(ctx, cfg.Command[0]); !ok {
+			logf.FromContext(ctx).Info("iperf3 is older than 3.17 or of an unknown version, falling back to --json", "version", version)
+			cfg = cfg.WithJSON()
+		}
 	}
 
 	// Execute iperf3
 	var stdoutBuf bytes.Buffer
-	cmd := exec.CommandContext(context.Background(), cfg.Command[0], cfg.Command[1:]...)
+	cmd := exec.CommandContext(ctx, cfg.Command[0], cfg.Command[1:]...)
 	cmd.Stdout = io.MultiWriter(os.Stdout, &stdoutBuf)
 	cmd.Stderr = os.Stderr
+	_, stream := cfg.Args["--json-stream"]
+	if stream {
+		// Follow intervals as they are reported for the live throughput gauge
+		gauge := metrics.IntervalThroughput.WithLabelValues(cfg.TestCase, string(cfg.Server))
+		defer metrics.IntervalThroughput.DeleteLabelValues(cfg.TestCase, string(cfg.Server))
+		cmd.Stdout = io.MultiWriter(cmd.Stdout, &LineWriter{OnLine: func(line []byte) {
+			if interval, ok := parseInterval(line); ok {
+				gauge.Set(interval.Sum.BitsPerSecond)
+			}
+		}})
+	}
+	start := time.Now()
 	if err := cmd.Run(); err != nil {
 		return nil, fmt.Errorf("failed to execute iperf3: %w", err)
 	}
@@ -65,12 +91,9 @@ func Run(_ context.Context, cfg *config.Config) (report *Report, err error) {
 	}
 
 	if cfg.Mode == config.ModeClient {
+		metrics.Iperf3Duration.Observe(time.Since(start).Seconds())
 		// Parse JSON output
-		output := stdoutBuf.Bytes()
-		report = &Report{}
-		if err := json.Unmarshal(output, report); err != nil {
-			return nil, fmt.Errorf("failed to parse JSON output: %w", err)
-		}
+		return ParseReport(stdoutBuf.Bytes())
 	}
 	return
 }
@@ -3,13 +3,26 @@ package iperf3_test
 import (
 	"cni-benchmark/pkg/config"
 	"cni-benchmark/pkg/iperf3"
+	"cni-benchmark/pkg/metrics"
 	"context"
+	"net"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	dto "github.com/prometheus/client_model/go"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
 )
 
 func TestIperf3(t *testing.T) {
@@ -55,5 +68,313 @@ var _ = Describe("iperf3", func() {
 			err := iperf3.WaitForServer(ctx, cfg)
 			Expect(err).To(HaveOccurred())
 		})
+
+		It("should reach an IPv6 server", func() {
+			listener, err := net.Listen("tcp6", "[::1]:0")
+			if err != nil {
+				Skip("IPv6 loopback is not available: " + err.Error())
+			}
+			defer listener.Close()
+			cfg.Server = "::1"
+			cfg.Port = uint16(listener.Addr().(*net.TCPAddr).Port)
+			cfg.IPFamily = config.IPFamilyIPv6
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			Expect(iperf3.WaitForServer(ctx, cfg)).To(Succeed())
+		})
+	})
+
+	Context("Run", func() {
+		It("should assemble the report from streamed output", func() {
+			script := filepath.Join(GinkgoT().TempDir(), "iperf3")
+			Expect(os.WriteFile(script, []byte(`#!/bin/sh
+[ "$1" = --version ] && echo 'iperf 3.18 (cJSON 1.7.15)' && exit
+echo '{"event":"start","data":{"version":"iperf 3.18","test_start":{"protocol":"TCP"}}}'
+echo '{"event":"interval","data":{"sum":{"start":0,"end":1,"seconds":1,"bytes":1000,"bits_per_second":8000}}}'
+echo '{"event":"interval","data":{"sum":{"start":1,"end":2,"seconds":1,"bytes":2000,"bits_per_second":16000}}}'
+echo '{"event":"end","data":{"sum_sent":{"seconds":2,"bytes":3000,"bits_per_second":12000,"retransmits":2},"sum_received":{"seconds":2,"bytes":3000,"bits_per_second":12000}}}'
+`), 0o700)).To(Succeed())
+			cfg.Command = []string{script}
+			delete(cfg.Args, "--json")
+			cfg.Args["--json-stream"] = ""
+			report, err := iperf3.Run(context.Background(), cfg.WithTarget("127.0.0.1", 5201))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(report.Start.Version).To(Equal("iperf 3.18"))
+			Expect(report.Intervals).To(HaveLen(2))
+			Expect(report.Intervals[1].Sum.BitsPerSecond).To(Equal(16000.0))
+			Expect(report.End.Sent.Retransmits).To(Equal(uint64(2)))
+			Expect(report.End.Received.Bytes).To(Equal(uint64(3000)))
+		})
+
+		It("should fall back to --json on iperf3 before 3.17", func() {
+			dir := GinkgoT().TempDir()
+			script := filepath.Join(dir, "iperf3")
+			Expect(os.WriteFile(script, []byte(`#!/bin/sh
+[ "$1" = --version ] && echo 'iperf 3.16 (cJSON 1.7.15)' && exit
+echo "$@" > `+filepath.Join(dir, "args")+`
+echo '{"start":{"version":"iperf 3.16","test_start":{"protocol":"TCP"}},"intervals":[],"end":{}}'
+`), 0o700)).To(Succeed())
+			cfg.Command = []string{script}
+			delete(cfg.Args, "--json")
+			cfg.Args["--json-stream"] = ""
+			report, err := iperf3.Run(context.Background(), cfg.WithTarget("127.0.0.1", 5201))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(report.Start.Version).To(Equal("iperf 3.16"))
+			args, err := os.ReadFile(filepath.Join(dir, "args"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(strings.Fields(string(args))).To(And(ContainElement("--json"), Not(ContainElement("--json-stream"))))
+		})
+
+		It("should observe the server wait once per target", func() {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+			DeferCleanup(listener.Close)
+			go func() {
+				for {
+					conn, err := listener.Accept()
+					if err != nil {
+						return
+					}
+					conn.Close()
+				}
+			}()
+			script := filepath.Join(GinkgoT().TempDir(), "iperf3")
+			Expect(os.WriteFile(script, []byte("#!/bin/sh\necho '{\"start\": {\"version\": \"iperf 3.18\"}}'\n"), 0o700)).To(Succeed())
+			cfg.Command = []string{script}
+			targetCfg := cfg.WithTarget("127.0.0.1", uint16(listener.Addr().(*net.TCPAddr).Port))
+
+			observations := func() uint64 {
+				var sample dto.Metric
+				Expect(metrics.WaitForServer.Write(&sample)).To(Succeed())
+				return sample.GetHistogram().GetSampleCount()
+			}
+			before := observations()
+			Expect(iperf3.WaitForServer(context.Background(), targetCfg)).To(Succeed())
+			_, err = iperf3.Run(context.Background(), targetCfg)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(observations()).To(Equal(before + 1))
+		})
+
+		It("should stop iperf3 when the context is cancelled", func() {
+			script := filepath.Join(GinkgoT().TempDir(), "iperf3")
+			Expect(os.WriteFile(script, []byte("#!/bin/sh\nexec sleep 30\n"), 0o700)).To(Succeed())
+			cfg.Command = []string{script}
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			_, err := iperf3.Run(ctx, cfg.WithTarget("127.0.0.1", 5201))
+			Expect(err).To(HaveOccurred())
+			Expect(time.Since(start)).To(BeNumerically("<", 10*time.Second))
+		})
+
+		It("should follow lines as they are written", func() {
+			var lines []string
+			writer := &iperf3.LineWriter{OnLine: func(line []byte) { lines = append(lines, string(line)) }}
+			for _, chunk := range []string{"fir", "st\nsec", "ond\n", "third"} {
+				_, err := writer.Write([]byte(chunk))
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Expect(lines).To(Equal([]string{"first", "second"}))
+		})
+
+		It("should parse saved output of both JSON formats", func() {
+			report, err := iperf3.ParseReport([]byte(`{
+  "start": {"version": "iperf 3.16", "test_start": {"protocol": "TCP"}},
+  "intervals": [{"sum": {"start": 0, "end": 1, "bits_per_second": 8e9}}]
+}`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(report.Start.Version).To(Equal("iperf 3.16"))
+			Expect(report.Intervals).To(HaveLen(1))
+
+			report, err = iperf3.ParseReport([]byte(`{"event":"start","data":{"version":"iperf 3.17"}}
+{"event":"interval","data":{"sum":{"start":0,"end":1,"bits_per_second":8e9}}}
+{"event":"interval","data":{"sum":{"start":1,"end":2,"bits_per_second":9e9}}}
+`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(report.Start.Version).To(Equal("iperf 3.17"))
+			Expect(report.Intervals).To(HaveLen(2))
+
+			_, err = iperf3.ParseReport([]byte("iperf3: error"))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should detect the IP family of the connection", func() {
+			for remote, family := range map[string]config.IPFamily{
+				"10.0.0.1":        config.IPFamilyIPv4,
+				"::ffff:10.0.0.1": config.IPFamilyIPv4,
+				"fd00::1":         config.IPFamilyIPv6,
+			} {
+				report, err := iperf3.ParseReport([]byte(`{"start": {"connected": [{"remote_host": "` + remote + `"}]}}`))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(report.IPFamily()).To(Equal(family))
+			}
+			Expect((&iperf3.Report{}).IPFamily()).To(Equal(config.IPFamilyAny))
+		})
+	})
+
+	Context("Migrate", func() {
+		It("should keep plain tables without TimescaleDB", func() {
+			db, err := gorm.Open(sqlite.Open("file:"+filepath.Join(GinkgoT().TempDir(), "plain.db")), &gorm.Config{})
+			Expect(err).ToNot(HaveOccurred())
+			cfg.Database.Timescale = config.Timescale{Enabled: true, Retention: 30 * 24 * time.Hour}
+			Expect(iperf3.Migrate(context.Background(), cfg, db)).To(Succeed())
+			Expect(iperf3.Migrate(context.Background(), cfg, db)).To(Succeed())
+			Expect(db.Migrator().HasTable(&iperf3.Metric{})).To(BeTrue())
+			Expect(db.Migrator().HasTable(&iperf3.Label{})).To(BeTrue())
+			Expect(db.Migrator().HasTable(&iperf3.Host{})).To(BeTrue())
+			Expect(db.Migrator().HasTable("metrics_by_run")).To(BeFalse())
+		})
+	})
+
+	Context("Locate", func() {
+		node := func(name, zone, instanceType string) *corev1.Node {
+			return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{
+				corev1.LabelTopologyZone:       zone,
+				corev1.LabelInstanceTypeStable: instanceType,
+			}}}
+		}
+		var client *fake.Clientset
+
+		BeforeEach(func() {
+			client = fake.NewClientset(
+				node("worker-1", "zone-a", "m5.large"),
+				node("worker-2", "zone-b", "c5.xlarge"),
+				&corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Name: "client", Namespace: "bench"},
+					Spec:       corev1.PodSpec{NodeName: "worker-1"},
+				},
+			)
+			cfg.Pod = config.Pod{Name: "client", Namespace: "bench"}
+		})
+
+		It("should record cross zone placement from the client pod", func() {
+			info := &iperf3.Info{ServerNodeName: "worker-2"}
+			Expect(info.Locate(context.Background(), client, cfg)).To(Succeed())
+			Expect(info.ClientNodeName).To(Equal("worker-1"))
+			Expect(info.ClientZone).To(Equal("zone-a"))
+			Expect(info.ClientInstanceType).To(Equal("m5.large"))
+			Expect(info.ServerZone).To(Equal("zone-b"))
+			Expect(info.ServerInstanceType).To(Equal("c5.xlarge"))
+			Expect(info.SameNode).To(HaveValue(BeFalse()))
+			Expect(info.CrossZone).To(HaveValue(BeTrue()))
+		})
+
+		It("should prefer the node name from the downward API", func() {
+			cfg.Pod.NodeName = "worker-2"
+			info := &iperf3.Info{ServerNodeName: "worker-2"}
+			Expect(info.Locate(context.Background(), client, cfg)).To(Succeed())
+			Expect(info.SameNode).To(HaveValue(BeTrue()))
+			Expect(info.CrossZone).To(HaveValue(BeFalse()))
+		})
+
+		It("should leave the placement unknown without a server node", func() {
+			info := &iperf3.Info{SameNode: ptr.To(true)}
+			Expect(info.Locate(context.Background(), client, cfg)).To(Succeed())
+			Expect(info.ClientNodeName).To(Equal("worker-1"))
+			Expect(info.SameNode).To(BeNil())
+			Expect(info.CrossZone).To(BeNil())
+		})
+
+		It("should fail for unknown nodes", func() {
+			info := &iperf3.Info{ServerNodeName: "missing"}
+			Expect(info.Locate(context.Background(), client, cfg)).ToNot(Succeed())
+		})
+	})
+
+	Context("Build", func() {
+		var client *fake.Clientset
+
+		BeforeEach(func() {
+			client = fake.NewClientset(&corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "worker-1"},
+				Status: corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{
+					OSImage:                 "Ubuntu 24.04.1 LTS",
+					ContainerRuntimeVersion: "containerd://1.7.24",
+					KubeletVersion:          "v1.32.1",
+				}},
+			})
+			client.Discovery().(*fakediscovery.FakeDiscovery).FakedServerVersion = &version.Info{GitVersion: "v1.32.1"}
+			cfg.K8sClient = client
+			cfg.Pod = config.Pod{NodeName: "worker-1"}
+			cfg.Detect = config.Detect{CNIConfDir: GinkgoT().TempDir(), ProcDir: "/nonexistent", SysDir: "/nonexistent"}
+		})
+
+		It("should require a client", func() {
+			cfg.K8sClient = nil
+			Expect((&iperf3.Info{}).Build(context.Background(), cfg)).ToNot(Succeed())
+		})
+
+		It("should read prefixed keys from a single ConfigMap", func() {
+			_, err := client.CoreV1().ConfigMaps("bench").Create(context.Background(), &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "bench-info", Namespace: "bench"},
+				Data: map[string]string{
+					"BENCH_OS_NAME":      "Talos",
+					"BENCH_K8S_PROVIDER": "kind",
+					"BENCH_CNI_NAME":     "cilium",
+					"BENCH_CNI_VERSION":  "1.16.5",
+					"K8S_VERSION":        "1.0.0",
+				},
+			}, metav1.CreateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			cfg.Info.Namespace = "bench"
+			cfg.Info.ConfigMap = "bench-info"
+			cfg.Info.KeyPrefix = "BENCH_"
+
+			info := &iperf3.Info{}
+			Expect(info.Build(context.Background(), cfg)).To(Succeed())
+			Expect(info.OsName).To(Equal("Talos"))
+			Expect(info.OsVersion).To(BeEmpty())
+			Expect(info.K8sProvider).To(Equal("kind"))
+			Expect(info.K8sVersion).To(Equal("1.32.1"))
+			Expect(info.CNIName).To(Equal("cilium"))
+			Expect(info.CNIVersion).To(Equal("1.16.5"))
+		})
+
+		It("should read the configuration and the host in standalone mode", func() {
+			path := filepath.Join(GinkgoT().TempDir(), "os-release")
+			Expect(os.WriteFile(path, []byte("PRETTY_NAME=\"Debian GNU/Linux 12 (bookworm)\"\n"), 0o600)).To(Succeed())
+			cfg.Info.Values = map[string]string{"k8s_provider": "bare-metal"}
+			cfg.K8sClient = nil
+			cfg.Standalone = true
+			cfg.Detect.OSRelease = path
+			cfg.ExtraLabels = config.LabelMap{"baseline": "true"}
+
+			info := &iperf3.Info{}
+			Expect(info.Build(context.Background(), cfg)).To(Succeed())
+			Expect(info.OsName).To(Equal("Debian GNU/Linux"))
+			Expect(info.OsVersion).To(Equal("12 (bookworm)"))
+			Expect(info.K8sProvider).To(Equal("bare-metal"))
+			Expect(info.K8sVersion).To(BeEmpty())
+			Expect(info.CNIName).To(Equal("none"))
+			Expect(info.Labels).To(Equal(map[string]string{"baseline": "true"}))
+		})
+
+		It("should take names and versions as pairs from one source", func() {
+			_, err := client.CoreV1().ConfigMaps("default").Create(context.Background(), &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "bench-info", Namespace: "default"},
+				Data: map[string]string{
+					"OS_NAME":              "ubuntu",
+					"K8S_PROVIDER_VERSION": "1.2.3",
+				},
+			}, metav1.CreateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			cfg.Info.ConfigMap = "bench-info"
+
+			info := &iperf3.Info{}
+			Expect(info.Build(context.Background(), cfg)).To(Succeed())
+			Expect(info.OsName).To(Equal("ubuntu"))
+			Expect(info.OsVersion).To(Equal("24.04.1 LTS"))
+			Expect(info.K8sProvider).To(BeEmpty())
+			Expect(info.K8sProviderVersion).To(Equal("1.2.3"))
+		})
+
+		It("should leave missing values empty", func() {
+			info := &iperf3.Info{}
+			Expect(info.Build(context.Background(), cfg)).To(Succeed())
+			Expect(info.K8sProvider).To(BeEmpty())
+			Expect(info.CNIName).To(BeEmpty())
+			Expect(info.OsName).To(Equal("Ubuntu"))
+			Expect(info.K8sKubeletVersion).To(Equal("1.32.1"))
+		})
 	})
 })
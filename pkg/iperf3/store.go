@@ -1,15 +1,18 @@
 package iperf3
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
 	"time"
 
-	"github.com/cenkalti/backoff/v4"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
 
 	config "cni-benchmark/pkg/config"
+	"cni-benchmark/pkg/metrics"
+	"cni-benchmark/pkg/tracing"
 
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 )
@@ -19,32 +22,27 @@ func Store(ctx context.Context, cfg *config.Config, report *Report, info *Info)
 	if cfg == nil {
 		return errors.New("configuration is required")
 	}
+	ctx, span := tracing.Start(ctx, "store", trace.WithAttributes(info.Attributes()...))
+	defer func() { tracing.End(span, err) }()
 
-	b := backoff.NewExponentialBackOff()
-	b.MaxElapsedTime = 5 * time.Minute
-	b.InitialInterval = 100 * time.Millisecond
-	b.MaxInterval = 2 * time.Second
-	log.Info("pushing metrics to the database with backoff")
-
+	// The connection is reused across attempts, credentials are still
+	// resolved on every attempt to pick up rotated ones
+	conn := cfg.Connect()
+	defer conn.Close()
 	operation := func() error {
-		log.Info("connecting to the database", "url", cfg.DatabaseDialector.Name())
-		db, err := gorm.Open(cfg.DatabaseDialector, &gorm.Config{})
+		db, err := conn.DB(ctx)
 		if err != nil {
-			return fmt.Errorf("failed to connect to database: %w", err)
+			return err
 		}
-		if err = db.AutoMigrate(&Metric{}); err != nil {
+		log.V(1).Info("using the database", "type", db.Name())
+		if err = db.AutoMigrate(&Metric{}, &Label{}, &Host{}); err != nil {
 			return fmt.Errorf("failed to migrate database: %w", err)
 		}
 
 		return storeWithTransaction(ctx, cfg, db, report, info)
 	}
 
-	if err = backoff.Retry(operation, b); err != nil {
-		return fmt.Errorf("failed to store metrics after retries: %w", err)
-	}
-
-	log.Info("successfully pushed metrics to the database")
-	return
+	return metrics.RetryStore(ctx, "iperf3", operation)
 }
 
 func storeWithTransaction(ctx context.Context, cfg *config.Config, db *gorm.DB, report *Report, info *Info) error {
@@ -61,6 +59,33 @@ func storeWithTransaction(ctx context.Context, cfg *config.Config, db *gorm.DB,
 		}
 	}()
 
+	metrics := BuildMetrics(cfg, report, info)
+	if err := tx.Create(&metrics).Error; err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to create interval metrics: %w", err)
+	}
+
+	if err := StoreLabels(tx, info); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := StoreHost(tx, info); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// BuildMetrics turns report intervals into rows carrying the run info
+func BuildMetrics(cfg *config.Config, report *Report, info *Info) []*Metric {
+	// The connection iperf3 made wins over the requested family
+	info.IPFamily = string(cmp.Or(report.IPFamily(), config.IPFamily(info.IPFamily)))
 	// If AlignTime is true, set baseTime to 12:00 of the current day
 	var baseTime time.Time
 	if cfg.AlignTime {
@@ -92,14 +117,43 @@ func storeWithTransaction(ctx context.Context, cfg *config.Config, db *gorm.DB,
 		})
 	}
 
-	if err := tx.Create(&metrics).Error; err != nil {
-		tx.Rollback()
-		return fmt.Errorf("failed to create interval metrics: %w", err)
-	}
+	return metrics
+}
 
-	if err := tx.Commit().Error; err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
+// StoreLabels saves user-defined labels of the run
+func StoreLabels(tx *gorm.DB, info *Info) error {
+	if len(info.Labels) == 0 {
+		return nil
+	}
+	labels := make([]*Label, 0, len(info.Labels))
+	for key, value := range info.Labels {
+		labels = append(labels, &Label{RunID: info.RunID, Key: key, Value: value})
 	}
+	if err := tx.Create(&labels).Error; err != nil {
+		return fmt.Errorf("failed to create labels: %w", err)
+	}
+	return nil
+}
 
+// StoreHost saves the host snapshot of the run
+func StoreHost(tx *gorm.DB, info *Info) error {
+	if info.Host == nil {
+		return nil
+	}
+	if err := tx.Create(&Host{RunID: info.RunID, Snapshot: info.Host}).Error; err != nil {
+		return fmt.Errorf("failed to create host snapshot: %w", err)
+	}
 	return nil
 }
+
+// WithLabels is a scope which filters rows by labels of their runs
+func WithLabels(labels map[string]string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		for key, value := range labels {
+			runs := db.Session(&gorm.Session{NewDB: true}).Model(&Label{}).
+				Select("run_id").Where(map[string]any{"key": key, "value": value})
+			db = db.Where("run_id IN (?)", runs)
+		}
+		return db
+	}
+}
@@ -0,0 +1,108 @@
+package iperf3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+)
+
+// versionPattern matches the release in the first line of iperf3 --version
+var versionPattern = regexp.MustCompile(`^iperf (\d+)\.(\d+)`)
+
+// streamSupported tells whether the iperf3 binary has --json-stream, which
+// came with 3.17
+func streamSupported(ctx context.Context, command string) (version string, ok bool) {
+	out, err := exec.CommandContext(ctx, command, "--version").Output()
+	match := versionPattern.FindSubmatch(out)
+	if err != nil || match == nil {
+		return "", false
+	}
+	major, _ := strconv.Atoi(string(match[1]))
+	minor, _ := strconv.Atoi(string(match[2]))
+	return string(match[0]), major > 3 || (major == 3 && minor >= 17)
+}
+
+// LineWriter calls OnLine for every complete line written to it, so output
+// of a running process can be followed
+type LineWriter struct {
+	OnLine  func(line []byte)
+	pending []byte
+}
+
+func (w *LineWriter) Write(p []byte) (int, error) {
+	w.pending = append(w.pending, p...)
+	for {
+		i := bytes.IndexByte(w.pending, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		w.OnLine(w.pending[:i])
+		w.pending = w.pending[i+1:]
+	}
+}
+
+// streamEvent is a line of the --json-stream output
+type streamEvent struct {
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// parseInterval returns the interval of a --json-stream line, ok is false
+// for other events
+func parseInterval(line []byte) (interval Interval, ok bool) {
+	var event streamEvent
+	if json.Unmarshal(line, &event) != nil || event.Event != "interval" {
+		return interval, false
+	}
+	return interval, json.Unmarshal(event.Data, &interval) == nil
+}
+
+// parseStream assembles the report from the --json-stream output, whose
+// start, interval and end events carry the sections of the --json report
+func parseStream(output []byte) (*Report, error) {
+	report := &Report{}
+	for _, line := range bytes.Split(output, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var event streamEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			return nil, fmt.Errorf("failed to parse JSON stream event: %w", err)
+		}
+		var err error
+		switch event.Event {
+		case "start":
+			err = json.Unmarshal(event.Data, &report.Start)
+		case "interval":
+			var interval Interval
+			err = json.Unmarshal(event.Data, &interval)
+			report.Intervals = append(report.Intervals, interval)
+		case "end":
+			err = json.Unmarshal(event.Data, &report.End)
+		case "error":
+			return nil, fmt.Errorf("iperf3 reported an error: %s", event.Data)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse JSON stream %s event: %w", event.Event, err)
+		}
+	}
+	return report, nil
+}
+
+// ParseReport parses saved iperf3 output of either --json or --json-stream
+func ParseReport(output []byte) (*Report, error) {
+	line, _, _ := bytes.Cut(bytes.TrimSpace(output), []byte("\n"))
+	var event streamEvent
+	if json.Unmarshal(line, &event) == nil && len(event.Event) > 0 {
+		return parseStream(output)
+	}
+	report := &Report{}
+	if err := json.Unmarshal(output, report); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON output: %w", err)
+	}
+	return report, nil
+}
@@ -0,0 +1,149 @@
+package iperf3
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	config "cni-benchmark/pkg/config"
+
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Continuous aggregates of the metrics hypertable, by their name suffix
+var aggregates = []struct {
+	suffix     string
+	bucket     time.Duration
+	dimensions string
+	schedule   time.Duration
+}{
+	// Throughput of every run, minute by minute
+	{"_by_run", time.Minute, "run_id, test_case, cni_name, target_type, ip_family", 5 * time.Minute},
+	// Throughput of every CNI version, hour by hour
+	{"_by_cni", time.Hour, "cni_name, cni_version, test_case, target_type, ip_family", time.Hour},
+}
+
+// Migrate creates or updates the iperf3 tables. With TimescaleDB enabled and
+// installed the metrics table becomes a hypertable with continuous aggregates
+// and retention policies, other databases get the plain tables. Stores only
+// create missing tables, settings are applied here.
+func Migrate(ctx context.Context, cfg *config.Config, db *gorm.DB) error {
+	db = db.WithContext(ctx)
+	if err := db.AutoMigrate(&Metric{}, &Label{}, &Host{}); err != nil {
+		return fmt.Errorf("failed to migrate database: %w", err)
+	}
+	if cfg == nil || !cfg.Database.Timescale.Enabled || db.Name() != "postgres" {
+		return nil
+	}
+	var installed bool
+	if err := db.Raw("SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'timescaledb')").Scan(&installed).Error; err != nil {
+		return fmt.Errorf("failed to look up the timescaledb extension: %w", err)
+	}
+	if !installed {
+		logf.FromContext(ctx).Info("timescaledb extension is not installed, keeping plain tables")
+		return nil
+	}
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(&Metric{}); err != nil {
+		return err
+	}
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		return timescale(tx, stmt.Schema.Table, &cfg.Database.Timescale)
+	}); err != nil {
+		return fmt.Errorf("failed to migrate timescaledb: %w", err)
+	}
+	return nil
+}
+
+// timescale converts the metrics table and creates the aggregates, every
+// step can be run again
+func timescale(tx *gorm.DB, table string, settings *config.Timescale) error {
+	var hypertable bool
+	if err := tx.Raw(`SELECT EXISTS (SELECT 1 FROM timescaledb_information.hypertables
+		WHERE format('%I.%I', hypertable_schema, hypertable_name)::regclass = ?::regclass)`, table).Scan(&hypertable).Error; err != nil {
+		return err
+	}
+	var primaryKey string
+	if !hypertable {
+		if err := tx.Raw("SELECT conname FROM pg_constraint WHERE conrelid = ?::regclass AND contype = 'p'", table).Scan(&primaryKey).Error; err != nil {
+			return err
+		}
+	}
+	for _, s := range statements(table, hypertable, primaryKey, settings) {
+		if err := tx.Exec(s.sql, s.vars...).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// statement is a query with its parameters
+type statement struct {
+	sql  string
+	vars []any
+}
+
+// statements sets up the metrics table, whether it is a hypertable already
+// and its primary key decide the conversion steps
+func statements(table string, hypertable bool, primaryKey string, settings *config.Timescale) (s []statement) {
+	if !hypertable {
+		// Unique indexes of a hypertable have to include the time column
+		if len(primaryKey) > 0 {
+			s = append(s, statement{`ALTER TABLE ? DROP CONSTRAINT ?, ADD PRIMARY KEY (id, "timestamp")`,
+				[]any{clause.Table{Name: table}, clause.Column{Name: primaryKey}}})
+		}
+		if settings.ChunkInterval > 0 {
+			s = append(s, statement{"SELECT create_hypertable(?::regclass, 'timestamp', chunk_time_interval => ?::interval, migrate_data => true, if_not_exists => true)",
+				[]any{table, interval(settings.ChunkInterval)}})
+		} else {
+			s = append(s, statement{"SELECT create_hypertable(?::regclass, 'timestamp', migrate_data => true, if_not_exists => true)",
+				[]any{table}})
+		}
+	} else if settings.ChunkInterval > 0 {
+		// Applies to chunks created from now on
+		s = append(s, statement{"SELECT set_chunk_time_interval(?::regclass, ?::interval)",
+			[]any{table, interval(settings.ChunkInterval)}})
+	}
+	s = append(s, retention(table, settings.Retention)...)
+
+	for _, aggregate := range aggregates {
+		view := table + aggregate.suffix
+		// Real-time aggregates also cover intervals not materialized yet. View
+		// definitions take no parameters, the bucket is written out.
+		s = append(s, statement{fmt.Sprintf(`CREATE MATERIALIZED VIEW IF NOT EXISTS ?
+			WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+			SELECT time_bucket(INTERVAL '%[2]s', "timestamp") AS bucket, %[1]s,
+				count(*) AS intervals,
+				avg(bandwidth_bps) AS mean_bps,
+				min(bandwidth_bps) AS min_bps,
+				max(bandwidth_bps) AS max_bps,
+				sum(bytes) AS bytes,
+				sum(retransmits) AS retransmits
+			FROM ?
+			GROUP BY bucket, %[1]s
+			WITH NO DATA`, aggregate.dimensions, interval(aggregate.bucket)),
+			[]any{clause.Table{Name: view}, clause.Table{Name: table}}})
+		s = append(s, statement{`SELECT add_continuous_aggregate_policy(?::regclass,
+			start_offset => ?::interval, end_offset => ?::interval, schedule_interval => ?::interval, if_not_exists => true)`,
+			[]any{view, interval(config.TimescaleRefreshWindow), interval(aggregate.bucket), interval(aggregate.schedule)}})
+		s = append(s, retention(view, settings.AggregateRetention)...)
+	}
+	return
+}
+
+// retention replaces the retention policy of the relation, zero removes it
+func retention(relation string, age time.Duration) []statement {
+	s := []statement{{"SELECT remove_retention_policy(?::regclass, if_exists => true)", []any{relation}}}
+	if age == 0 {
+		return s
+	}
+	return append(s, statement{"SELECT add_retention_policy(?::regclass, drop_after => ?::interval)", []any{relation, interval(age)}})
+}
+
+// interval formats the duration as a Postgres interval
+func interval(d time.Duration) string {
+	return fmt.Sprintf("%d milliseconds", d.Milliseconds())
+}
@@ -0,0 +1,74 @@
+package iperf3
+
+import (
+	"cni-benchmark/pkg/config"
+	"strings"
+	"time"
+
+	// Named, the dot import clashes with Report and Label
+	"github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+var _ = ginkgo.Describe("TimescaleDB statements", func() {
+	var db *gorm.DB
+	settings := &config.Timescale{
+		Enabled:            true,
+		ChunkInterval:      24 * time.Hour,
+		Retention:          30 * 24 * time.Hour,
+		AggregateRetention: 365 * 24 * time.Hour,
+	}
+
+	ginkgo.BeforeEach(func() {
+		var err error
+		db, err = gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{DryRun: true, DisableAutomaticPing: true})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	render := func(s []statement) []string {
+		sql := make([]string, len(s))
+		for i := range s {
+			sql[i] = strings.Join(strings.Fields(db.ToSQL(func(tx *gorm.DB) *gorm.DB {
+				return tx.Exec(s[i].sql, s[i].vars...)
+			})), " ")
+		}
+		return sql
+	}
+
+	ginkgo.It("should convert the table into a hypertable", func() {
+		sql := render(statements("bench.metrics", false, "metrics_pkey", settings))
+		Expect(sql[:4]).To(Equal([]string{
+			`ALTER TABLE "bench"."metrics" DROP CONSTRAINT "metrics_pkey", ADD PRIMARY KEY (id, "timestamp")`,
+			`SELECT create_hypertable('bench.metrics'::regclass, 'timestamp', chunk_time_interval => '86400000 milliseconds'::interval, migrate_data => true, if_not_exists => true)`,
+			`SELECT remove_retention_policy('bench.metrics'::regclass, if_exists => true)`,
+			`SELECT add_retention_policy('bench.metrics'::regclass, drop_after => '2592000000 milliseconds'::interval)`,
+		}))
+	})
+
+	ginkgo.It("should create the aggregates with their policies", func() {
+		sql := render(statements("metrics", false, "", settings))
+		Expect(sql).ToNot(ContainElement(ContainSubstring("ALTER TABLE")))
+		Expect(sql).To(ContainElements(
+			And(
+				HavePrefix(`CREATE MATERIALIZED VIEW IF NOT EXISTS "metrics_by_run" WITH (timescaledb.continuous, timescaledb.materialized_only = false)`),
+				ContainSubstring(`time_bucket(INTERVAL '60000 milliseconds', "timestamp") AS bucket, run_id, test_case, cni_name, target_type, ip_family,`),
+				ContainSubstring(`FROM "metrics" GROUP BY bucket, run_id`),
+			),
+			`SELECT add_continuous_aggregate_policy('metrics_by_run'::regclass, start_offset => '172800000 milliseconds'::interval, end_offset => '60000 milliseconds'::interval, schedule_interval => '300000 milliseconds'::interval, if_not_exists => true)`,
+			HavePrefix(`CREATE MATERIALIZED VIEW IF NOT EXISTS "metrics_by_cni"`),
+			`SELECT add_retention_policy('metrics_by_cni'::regclass, drop_after => '31536000000 milliseconds'::interval)`,
+		))
+	})
+
+	ginkgo.It("should only update the settings of a hypertable", func() {
+		sql := render(statements("metrics", true, "", &config.Timescale{ChunkInterval: time.Hour}))
+		Expect(sql[:2]).To(Equal([]string{
+			`SELECT set_chunk_time_interval('metrics'::regclass, '3600000 milliseconds'::interval)`,
+			`SELECT remove_retention_policy('metrics'::regclass, if_exists => true)`,
+		}))
+		Expect(sql).ToNot(ContainElement(ContainSubstring("create_hypertable")))
+		Expect(sql).ToNot(ContainElement(ContainSubstring("add_retention_policy")))
+	})
+})
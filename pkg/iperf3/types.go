@@ -2,6 +2,9 @@ package iperf3
 
 import (
 	"time"
+
+	config "cni-benchmark/pkg/config"
+	"cni-benchmark/pkg/detect"
 )
 
 type Report struct {
@@ -11,6 +14,9 @@ type Report struct {
 	} `json:"system"`
 	Start struct {
 		Version   string `json:"version"`
+		Connected []struct {
+			RemoteHost string `json:"remote_host"`
+		} `json:"connected"`
 		Timestamp struct {
 			Seconds uint `json:"timesecs"`
 		} `json:"timestamp"`
@@ -28,6 +34,17 @@ type Report struct {
 	} `json:"end"`
 }
 
+// IPFamily returns the family of the connection iperf3 made, empty when the
+// report has no connection details
+func (r *Report) IPFamily() config.IPFamily {
+	for _, connection := range r.Start.Connected {
+		if family := config.Address(connection.RemoteHost).Family(); family != config.IPFamilyAny {
+			return family
+		}
+	}
+	return config.IPFamilyAny
+}
+
 type ReportSum struct {
 	DurationSeconds float64 `json:"seconds"`
 	Bytes           uint64  `json:"bytes"`
@@ -58,19 +75,53 @@ type Metric struct {
 	IntervalEnd     float64 `gorm:"not null;check:interval_end >= interval_start"`
 }
 
+// Label is a user-defined key/value attached to a run
+type Label struct {
+	ID    uint   `gorm:"primaryKey"`
+	RunID string `gorm:"type:varchar(36);not null;uniqueIndex:idx_labels_run_key"`
+	Key   string `gorm:"type:varchar(100);not null;uniqueIndex:idx_labels_run_key;index"`
+	Value string `gorm:"type:varchar(200);not null;index"`
+}
+
+// Host is the host networking snapshot of a run, stored as a JSON document
+type Host struct {
+	RunID    string       `gorm:"type:varchar(36);primaryKey"`
+	Snapshot *detect.Host `gorm:"type:text;serializer:json"`
+}
+
 // Extra information about the test environment
 type Info struct {
-	TestCase           string `gorm:"type:varchar(100);index"`
-	OsName             string `gorm:"type:varchar(50);index;not null"`
-	OsVersion          string `gorm:"type:varchar(50);index;not null"`
-	OsKernelArch       string `gorm:"type:varchar(50);index;not null"`
-	OsKernelVersion    string `gorm:"type:varchar(100);index;not null"`
-	K8sProvider        string `gorm:"type:varchar(50);index;not null"`
-	K8sProviderVersion string `gorm:"type:varchar(50);index;not null"`
-	K8sVersion         string `gorm:"type:varchar(50);index;not null"`
-	CNIName            string `gorm:"type:varchar(50);index;not null"`
-	CNIVersion         string `gorm:"type:varchar(50);index;not null"`
-	CNIDescription     string `gorm:"type:varchar(200);index;not null;column:cni_description"`
-	Iperf3Version      string `gorm:"type:varchar(50);index;not null"`
-	Iperf3Protocol     string `gorm:"type:varchar(20);index;not null"`
+	TestCase            string `gorm:"type:varchar(100);index"`
+	OsName              string `gorm:"type:varchar(50);index;not null"`
+	OsVersion           string `gorm:"type:varchar(50);index;not null"`
+	OsKernelArch        string `gorm:"type:varchar(50);index;not null"`
+	OsKernelVersion     string `gorm:"type:varchar(100);index;not null"`
+	K8sProvider         string `gorm:"type:varchar(50);index;not null"`
+	K8sProviderVersion  string `gorm:"type:varchar(50);index;not null"`
+	K8sVersion          string `gorm:"type:varchar(50);index;not null"`
+	K8sContainerRuntime string `gorm:"type:varchar(100);index"`
+	K8sKubeletVersion   string `gorm:"type:varchar(50);index"`
+	CNIName             string `gorm:"type:varchar(50);index;not null"`
+	CNIVersion          string `gorm:"type:varchar(50);index;not null"`
+	CNIDescription      string `gorm:"type:varchar(200);index;not null;column:cni_description"`
+	Iperf3Version       string `gorm:"type:varchar(50);index;not null"`
+	Iperf3Protocol      string `gorm:"type:varchar(20);index;not null"`
+	TargetType          string `gorm:"type:varchar(20);index"`
+	IPFamily            string `gorm:"type:varchar(10);index"`
+	ServerNodeName      string `gorm:"type:varchar(253);index"`
+	ServerPodIP         string `gorm:"type:varchar(45);index"`
+	ServerZone          string `gorm:"type:varchar(100);index"`
+	ServerInstanceType  string `gorm:"type:varchar(100);index"`
+	ClientNodeName      string `gorm:"type:varchar(253);index"`
+	ClientZone          string `gorm:"type:varchar(100);index"`
+	ClientInstanceType  string `gorm:"type:varchar(100);index"`
+	// Placement flags, empty when either side is unknown
+	SameNode  *bool `gorm:"index"`
+	CrossZone *bool `gorm:"index"`
+	// Host networking snapshot stored once per run in a separate table
+	Host *detect.Host `gorm:"-"`
+	// Unique identifier of a single benchmark run
+	RunID string `gorm:"type:varchar(36);index"`
+	// User-defined labels stored in a separate table
+	Labels map[string]string `gorm:"-"`
 }
@@ -0,0 +1,150 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"cni-benchmark/pkg/tracing"
+
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const namespace = "cni_benchmark"
+
+// Run outcomes
+const (
+	OutcomeSucceeded = "succeeded"
+	OutcomeFailed    = "failed"
+	OutcomeSkipped   = "skipped"
+)
+
+var (
+	// Runs counts benchmark runs by mode and outcome
+	Runs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "runs_total",
+		Help:      "Benchmark runs by mode and outcome.",
+	}, []string{"mode", "outcome"})
+
+	// Iperf3Duration observes iperf3 client executions
+	Iperf3Duration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "iperf3_duration_seconds",
+		Help:      "Duration of iperf3 client executions.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+	})
+
+	// WaitForServer observes the time until the server accepts connections
+	WaitForServer = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "wait_for_server_seconds",
+		Help:      "Time spent waiting for the iperf3 server to accept connections.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 4, 10),
+	})
+
+	// StoreAttempts counts store attempts including retries by sink and result
+	StoreAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "store_attempts_total",
+		Help:      "Attempts to store results, including retries, by sink and result.",
+	}, []string{"sink", "result"})
+
+	// StoreDuration observes single store attempts by sink
+	StoreDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "store_duration_seconds",
+		Help:      "Duration of single attempts to store results by sink.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"sink"})
+
+	// IntervalThroughput is the throughput of the last interval of running
+	// iperf3 clients
+	IntervalThroughput = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "interval_bits_per_second",
+		Help:      "Throughput of the last reported interval of the running iperf3 client.",
+	}, []string{"test_case", "server"})
+
+	// AcceptedConnections counts connections accepted by iperf3 servers
+	AcceptedConnections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "server_accepted_connections_total",
+		Help:      "Connections accepted by iperf3 servers by port.",
+	}, []string{"port"})
+)
+
+// Registry holds the benchmark metrics along with the process and Go ones
+var Registry = prometheus.NewRegistry()
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collectors.NewGoCollector(),
+		Runs, Iperf3Duration, WaitForServer, StoreAttempts, StoreDuration, IntervalThroughput, AcceptedConnections,
+	)
+}
+
+// Handler serves the registry in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// Serve exposes /metrics on the address until the context is done
+func Serve(ctx context.Context, address string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	server := &http.Server{Addr: address, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	logf.FromContext(ctx).Info("serving metrics", "address", address)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// StoreAttempt wraps a store operation retried with backoff to count its
+// attempts and observe their duration
+func StoreAttempt(sink string, operation func() error) func() error {
+	return func() error {
+		start := time.Now()
+		err := operation()
+		StoreDuration.WithLabelValues(sink).Observe(time.Since(start).Seconds())
+		result := "success"
+		if err != nil {
+			result = "error"
+		}
+		StoreAttempts.WithLabelValues(sink, result).Inc()
+		return err
+	}
+}
+
+// RetryStore runs a store operation with exponential backoff until it
+// succeeds, fails permanently, the context is done or five minutes passed.
+// Every attempt is traced, counted and timed.
+func RetryStore(ctx context.Context, sink string, operation func() error) error {
+	log := logf.FromContext(ctx)
+	b := backoff.NewExponentialBackOff()
+	b.MaxElapsedTime = 5 * time.Minute
+	b.InitialInterval = 100 * time.Millisecond
+	b.MaxInterval = 2 * time.Second
+	log.Info("pushing results with backoff", "sink", sink)
+	attempt := tracing.Attempt(ctx, "store.attempt", StoreAttempt(sink, operation))
+	if err := backoff.Retry(attempt, backoff.WithContext(b, ctx)); err != nil {
+		return fmt.Errorf("failed to store %s results after retries: %w", sink, err)
+	}
+	log.Info("successfully pushed results", "sink", sink)
+	return nil
+}
@@ -0,0 +1,80 @@
+package metrics_test
+
+import (
+	"cni-benchmark/pkg/metrics"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cenkalti/backoff/v4"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Metrics")
+}
+
+var _ = Describe("Metrics", func() {
+	It("should count store attempts by result", func() {
+		calls := 0
+		operation := metrics.StoreAttempt("test", func() error {
+			calls++
+			if calls == 1 {
+				return errors.New("connection refused")
+			}
+			return nil
+		})
+		Expect(operation()).ToNot(Succeed())
+		Expect(operation()).To(Succeed())
+		Expect(testutil.ToFloat64(metrics.StoreAttempts.WithLabelValues("test", "error"))).To(Equal(1.0))
+		Expect(testutil.ToFloat64(metrics.StoreAttempts.WithLabelValues("test", "success"))).To(Equal(1.0))
+	})
+
+	It("should retry stores until they succeed or fail permanently", func() {
+		calls := 0
+		Expect(metrics.RetryStore(context.Background(), "retry", func() error {
+			if calls++; calls < 3 {
+				return errors.New("connection refused")
+			}
+			return nil
+		})).To(Succeed())
+		Expect(calls).To(Equal(3))
+		Expect(testutil.ToFloat64(metrics.StoreAttempts.WithLabelValues("retry", "error"))).To(Equal(2.0))
+
+		calls = 0
+		err := metrics.RetryStore(context.Background(), "retry", func() error {
+			calls++
+			return backoff.Permanent(errors.New("invalid URL"))
+		})
+		Expect(err).To(MatchError(ContainSubstring("failed to store retry results after retries: invalid URL")))
+		Expect(calls).To(Equal(1))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		Expect(metrics.RetryStore(ctx, "retry", func() error { return errors.New("connection refused") })).
+			To(MatchError(context.Canceled))
+	})
+
+	It("should expose the registry", func() {
+		metrics.Runs.WithLabelValues("client", metrics.OutcomeSucceeded).Inc()
+		recorder := httptest.NewRecorder()
+		metrics.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Body.String()).To(And(
+			ContainSubstring(`cni_benchmark_runs_total{mode="client",outcome="succeeded"} 1`),
+			ContainSubstring("cni_benchmark_store_duration_seconds"),
+			ContainSubstring("process_cpu_seconds_total"),
+		))
+	})
+
+	It("should pass the metrics linter", func() {
+		problems, err := testutil.GatherAndLint(metrics.Registry)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(problems).To(BeEmpty())
+	})
+})
@@ -0,0 +1,196 @@
+package queue
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	config "cni-benchmark/pkg/config"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Entry is a client waiting for or holding an execution slot
+type Entry struct {
+	ID         string    `json:"id"`
+	TestCase   string    `json:"test_case"`
+	Nodes      []string  `json:"nodes,omitempty"`
+	EnqueuedAt time.Time `json:"enqueued_at"`
+	Heartbeat  time.Time `json:"heartbeat"`
+	Running    bool      `json:"running"`
+}
+
+// Queue hands out execution slots to clients of all test cases in FIFO
+// order. Entries are kept in a ConfigMap, one key per client, and updated
+// with optimistic concurrency.
+type Queue struct {
+	client    kubernetes.Interface
+	cfg       config.Queue
+	namespace string
+}
+
+// New returns the queue configured for the client
+func New(client kubernetes.Interface, cfg *config.Config) *Queue {
+	return &Queue{client: client, cfg: cfg.Queue, namespace: cmp.Or(cfg.Queue.Namespace, cfg.Lease.Namespace)}
+}
+
+// Acquire enqueues the entry and blocks until it gets a slot. onPosition is
+// called with the 1-based queue position every time it changes while
+// waiting. The returned release function must be called to free the slot.
+func (q *Queue) Acquire(ctx context.Context, entry Entry, onPosition func(position int)) (release func(context.Context) error, err error) {
+	log := logf.FromContext(ctx).WithValues("queue", q.cfg.ConfigMap)
+	key := config.ConfigMapKey(entry.ID)
+	entry.EnqueuedAt = time.Now().UTC()
+	last := -1
+	for {
+		var position int
+		err = q.update(ctx, func(entries map[string]*Entry) {
+			if stored, ok := entries[key]; ok {
+				entry.EnqueuedAt = stored.EnqueuedAt
+			}
+			entry.Heartbeat = time.Now().UTC()
+			entries[key] = &entry
+			position = q.position(entries, key)
+			entry.Running = position < q.cfg.Slots
+		})
+		switch {
+		case apierrors.IsConflict(err):
+			// Busy queues keep changing under us, the next poll tries again
+			log.V(1).Info("queue changed concurrently, polling again")
+		case err != nil:
+			return nil, fmt.Errorf("failed to update the queue: %w", err)
+		case entry.Running:
+			log.Info("got an execution slot")
+			return q.hold(ctx, key), nil
+		case position != last:
+			last = position
+			onPosition(position - q.cfg.Slots + 1)
+		}
+
+		select {
+		case <-ctx.Done():
+			if err := q.remove(context.Background(), key); err != nil {
+				log.Error(err, "failed to leave the queue")
+			}
+			return nil, ctx.Err()
+		case <-time.After(q.cfg.PollInterval):
+		}
+	}
+}
+
+// hold keeps the heartbeat of a running entry until it is released
+func (q *Queue) hold(ctx context.Context, key string) func(context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(q.cfg.PollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				err := q.update(ctx, func(entries map[string]*Entry) {
+					if entry, ok := entries[key]; ok {
+						entry.Heartbeat = time.Now().UTC()
+					}
+				})
+				if err != nil && ctx.Err() == nil {
+					logf.FromContext(ctx).Error(err, "failed to renew the queue heartbeat")
+				}
+			}
+		}
+	}()
+	return func(ctx context.Context) error {
+		cancel()
+		<-done
+		return q.remove(ctx, key)
+	}
+}
+
+// position counts entries ahead of the key competing for the same slots,
+// running ones always count
+func (q *Queue) position(entries map[string]*Entry, key string) (position int) {
+	own := entries[key]
+	for other, entry := range entries {
+		if other == key || !q.conflicts(own, entry) {
+			continue
+		}
+		ahead := entry.EnqueuedAt.Compare(own.EnqueuedAt)
+		if entry.Running || ahead < 0 || (ahead == 0 && other < key) {
+			position++
+		}
+	}
+	return
+}
+
+// conflicts tells whether two entries compete for the same slots, all of
+// them do unless slots are handed out per node pair
+func (q *Queue) conflicts(a, b *Entry) bool {
+	if !q.cfg.PerNodePair || len(a.Nodes) == 0 || len(b.Nodes) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(a.Nodes, func(node string) bool { return slices.Contains(b.Nodes, node) })
+}
+
+func (q *Queue) remove(ctx context.Context, key string) error {
+	return q.update(ctx, func(entries map[string]*Entry) { delete(entries, key) })
+}
+
+// update applies fn to the live entries and writes them back, retrying on
+// conflicts. Entries without a heartbeat for TTL are dropped.
+func (q *Queue) update(ctx context.Context, fn func(entries map[string]*Entry)) error {
+	configMaps := q.client.CoreV1().ConfigMaps(q.namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := configMaps.Get(ctx, q.cfg.ConfigMap, metav1.GetOptions{})
+		create := apierrors.IsNotFound(err)
+		if create {
+			cm, err = &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+				Name:      q.cfg.ConfigMap,
+				Namespace: q.namespace,
+				Labels:    map[string]string{"app.kubernetes.io/managed-by": "cni-benchmark"},
+			}}, nil
+		}
+		if err != nil {
+			return err
+		}
+
+		entries := map[string]*Entry{}
+		for key, value := range cm.Data {
+			entry := &Entry{}
+			if json.Unmarshal([]byte(value), entry) != nil || time.Since(entry.Heartbeat) > q.cfg.TTL {
+				continue
+			}
+			entries[key] = entry
+		}
+		fn(entries)
+		cm.Data = make(map[string]string, len(entries))
+		for key, entry := range entries {
+			data, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			cm.Data[key] = string(data)
+		}
+
+		if create {
+			_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				// Lost the race to create it, retry as a conflict
+				return apierrors.NewConflict(corev1.Resource("configmaps"), cm.Name, err)
+			}
+			return err
+		}
+		_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+		return err
+	})
+}
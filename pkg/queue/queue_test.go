@@ -0,0 +1,133 @@
+package queue_test
+
+import (
+	"cni-benchmark/pkg/config"
+	"cni-benchmark/pkg/queue"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestQueue(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Queue")
+}
+
+var _ = Describe("Queue", func() {
+	var client *fake.Clientset
+	var cfg *config.Config
+	ctx := context.Background()
+
+	entries := func() map[string]string {
+		cm, err := client.CoreV1().ConfigMaps("bench").Get(ctx, "cni-benchmark-queue", metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		return cm.Data
+	}
+
+	BeforeEach(func() {
+		client = fake.NewClientset()
+		cfg = &config.Config{
+			Lease: config.Lease{Namespace: "bench"},
+			Queue: config.Queue{
+				ConfigMap: "cni-benchmark-queue", Slots: 1, PollInterval: 10 * time.Millisecond, TTL: time.Minute,
+			},
+		}
+	})
+
+	It("should hand out slots in order", func() {
+		q := queue.New(client, cfg)
+		release, err := q.Acquire(ctx, queue.Entry{ID: "a", TestCase: "01"}, func(int) { Fail("a should not wait") })
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries()).To(HaveKey("a"))
+
+		positions := make(chan int, 10)
+		acquired := make(chan func(context.Context) error)
+		go func() {
+			defer GinkgoRecover()
+			release, err := q.Acquire(ctx, queue.Entry{ID: "b", TestCase: "02"}, func(position int) { positions <- position })
+			Expect(err).ToNot(HaveOccurred())
+			acquired <- release
+		}()
+		Eventually(positions).Should(Receive(Equal(1)))
+		Consistently(acquired, 50*time.Millisecond).ShouldNot(Receive())
+
+		Expect(release(ctx)).To(Succeed())
+		var releaseB func(context.Context) error
+		Eventually(acquired).Should(Receive(&releaseB))
+		Expect(entries()).To(HaveLen(1))
+		Expect(releaseB(ctx)).To(Succeed())
+		Expect(entries()).To(BeEmpty())
+	})
+
+	It("should run disjoint node pairs at the same time", func() {
+		cfg.Queue.PerNodePair = true
+		q := queue.New(client, cfg)
+		wait := func(int) { Fail("should not wait") }
+		releaseA, err := q.Acquire(ctx, queue.Entry{ID: "a", Nodes: []string{"worker-1", "worker-2"}}, wait)
+		Expect(err).ToNot(HaveOccurred())
+		releaseB, err := q.Acquire(ctx, queue.Entry{ID: "b", Nodes: []string{"worker-3", "worker-4"}}, wait)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries()).To(HaveLen(2))
+		Expect(releaseA(ctx)).To(Succeed())
+		Expect(releaseB(ctx)).To(Succeed())
+	})
+
+	It("should drop stale entries", func() {
+		stale, err := json.Marshal(queue.Entry{ID: "gone", Running: true, Heartbeat: time.Now().Add(-time.Hour)})
+		Expect(err).ToNot(HaveOccurred())
+		_, err = client.CoreV1().ConfigMaps("bench").Create(ctx, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "cni-benchmark-queue", Namespace: "bench"},
+			Data:       map[string]string{"gone": string(stale)},
+		}, metav1.CreateOptions{})
+		Expect(err).ToNot(HaveOccurred())
+
+		release, err := queue.New(client, cfg).Acquire(ctx, queue.Entry{ID: "a"}, func(int) { Fail("should not wait") })
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries()).To(HaveLen(1))
+		Expect(release(ctx)).To(Succeed())
+	})
+
+	It("should keep polling while the queue changes concurrently", func() {
+		_, err := client.CoreV1().ConfigMaps("bench").Create(ctx, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "cni-benchmark-queue", Namespace: "bench"},
+		}, metav1.CreateOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		// More conflicts than a single update retries
+		conflicts := 0
+		client.PrependReactor("update", "configmaps", func(k8stesting.Action) (bool, runtime.Object, error) {
+			if conflicts++; conflicts <= 10 {
+				return true, nil, apierrors.NewConflict(corev1.Resource("configmaps"), "cni-benchmark-queue", errors.New("modified"))
+			}
+			return false, nil, nil
+		})
+
+		release, err := queue.New(client, cfg).Acquire(ctx, queue.Entry{ID: "a"}, func(int) { Fail("should not wait") })
+		Expect(err).ToNot(HaveOccurred())
+		Expect(conflicts).To(BeNumerically(">", 10))
+		Expect(entries()).To(HaveKey("a"))
+		Expect(release(ctx)).To(Succeed())
+	})
+
+	It("should leave the queue when cancelled", func() {
+		q := queue.New(client, cfg)
+		release, err := q.Acquire(ctx, queue.Entry{ID: "a"}, func(int) {})
+		Expect(err).ToNot(HaveOccurred())
+
+		waitCtx, cancel := context.WithCancel(ctx)
+		_, err = q.Acquire(waitCtx, queue.Entry{ID: "b"}, func(int) { cancel() })
+		Expect(err).To(MatchError(context.Canceled))
+		Expect(entries()).To(HaveLen(1))
+		Expect(release(ctx)).To(Succeed())
+	})
+})
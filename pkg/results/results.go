@@ -0,0 +1,155 @@
+package results
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"cni-benchmark/pkg/config"
+	"cni-benchmark/pkg/dns"
+	"cni-benchmark/pkg/iperf3"
+)
+
+// Run summarizes the stored intervals of a benchmark run
+type Run struct {
+	RunID       string    `json:"run_id"`
+	TestCase    string    `json:"test_case"`
+	TargetType  string    `json:"target_type"`
+	IPFamily    string    `json:"ip_family,omitempty"`
+	CNIName     string    `json:"cni_name"`
+	StartedAt   time.Time `json:"started_at"`
+	Intervals   int       `json:"intervals"`
+	MeanBps     float64   `json:"mean_bps"`
+	MinBps      float64   `json:"min_bps"`
+	MaxBps      float64   `json:"max_bps"`
+	Retransmits uint64    `json:"retransmits"`
+}
+
+// Filter selects stored runs, empty fields match everything
+type Filter struct {
+	TestCase string
+	Labels   map[string]string
+}
+
+// Comparison is the throughput change of a candidate test case against a
+// baseline for a target type
+type Comparison struct {
+	TargetType    string  `json:"target_type"`
+	BaselineRuns  int     `json:"baseline_runs"`
+	BaselineBps   float64 `json:"baseline_bps"`
+	CandidateRuns int     `json:"candidate_runs"`
+	CandidateBps  float64 `json:"candidate_bps"`
+	ChangePercent float64 `json:"change_percent"`
+}
+
+// Migrate creates or updates the tables of all result sinks
+func Migrate(ctx context.Context, cfg *config.Config, db *gorm.DB) error {
+	if err := iperf3.Migrate(ctx, cfg, db); err != nil {
+		return err
+	}
+	if err := db.WithContext(ctx).AutoMigrate(&dns.Metric{}); err != nil {
+		return fmt.Errorf("failed to migrate database: %w", err)
+	}
+	return nil
+}
+
+// Runs summarizes the iperf3 runs matching the filter, oldest first. Intervals
+// are aggregated by the database with functions every dialect has.
+func Runs(ctx context.Context, db *gorm.DB, filter Filter) ([]Run, error) {
+	query := db.WithContext(ctx).Model(&iperf3.Metric{}).Scopes(iperf3.WithLabels(filter.Labels))
+	if len(filter.TestCase) > 0 {
+		query = query.Where("test_case = ?", filter.TestCase)
+	}
+	// Info columns are the same on every interval of a run
+	rows, err := query.Select(`run_id, MIN(test_case), MIN(target_type), MIN(ip_family), MIN(cni_name),
+		MIN(?) AS started_at, COUNT(*), AVG(bandwidth_bps), MIN(bandwidth_bps), MAX(bandwidth_bps),
+		SUM(retransmits)`, clause.Column{Name: "timestamp"}).Group("run_id").Order("started_at, run_id").Rows()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query metrics: %w", err)
+	}
+	defer rows.Close()
+
+	var runs []Run
+	for rows.Next() {
+		var run Run
+		var startedAt timestamp
+		if err := rows.Scan(&run.RunID, &run.TestCase, &run.TargetType, &run.IPFamily, &run.CNIName, &startedAt,
+			&run.Intervals, &run.MeanBps, &run.MinBps, &run.MaxBps, &run.Retransmits); err != nil {
+			return nil, fmt.Errorf("failed to read metrics: %w", err)
+		}
+		run.StartedAt = startedAt.UTC()
+		runs = append(runs, run)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read metrics: %w", err)
+	}
+	return runs, nil
+}
+
+// timestamp scans an aggregated time, which SQLite returns as text
+type timestamp struct{ time.Time }
+
+func (t *timestamp) Scan(value any) (err error) {
+	switch v := value.(type) {
+	case time.Time:
+		t.Time = v
+		return nil
+	case []byte:
+		value = string(v)
+	}
+	text, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("unsupported timestamp of type %T", value)
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05.999999999-07:00", time.RFC3339Nano, "2006-01-02 15:04:05.999999999"} {
+		if t.Time, err = time.Parse(layout, text); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("failed to parse timestamp %q: %w", text, err)
+}
+
+// Compare compares the mean throughput of the candidate runs against the
+// baseline runs per target type
+func Compare(ctx context.Context, db *gorm.DB, baseline, candidate Filter) ([]Comparison, error) {
+	baselineRuns, err := Runs(ctx, db, baseline)
+	if err != nil {
+		return nil, err
+	}
+	candidateRuns, err := Runs(ctx, db, candidate)
+	if err != nil {
+		return nil, err
+	}
+	byType := map[string]*Comparison{}
+	get := func(targetType string) *Comparison {
+		if byType[targetType] == nil {
+			byType[targetType] = &Comparison{TargetType: targetType}
+		}
+		return byType[targetType]
+	}
+	for _, run := range baselineRuns {
+		c := get(run.TargetType)
+		c.BaselineRuns++
+		c.BaselineBps += (run.MeanBps - c.BaselineBps) / float64(c.BaselineRuns)
+	}
+	for _, run := range candidateRuns {
+		c := get(run.TargetType)
+		c.CandidateRuns++
+		c.CandidateBps += (run.MeanBps - c.CandidateBps) / float64(c.CandidateRuns)
+	}
+
+	comparisons := make([]Comparison, 0, len(byType))
+	for _, c := range byType {
+		if c.BaselineBps > 0 && c.CandidateRuns > 0 {
+			c.ChangePercent = (c.CandidateBps - c.BaselineBps) / c.BaselineBps * 100
+		}
+		comparisons = append(comparisons, *c)
+	}
+	slices.SortFunc(comparisons, func(a, b Comparison) int { return cmp.Compare(a.TargetType, b.TargetType) })
+	return comparisons, nil
+}
@@ -0,0 +1,75 @@
+package results_test
+
+import (
+	"cni-benchmark/pkg/iperf3"
+	"cni-benchmark/pkg/results"
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestResults(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Results")
+}
+
+var _ = Describe("Results", func() {
+	var db *gorm.DB
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	store := func(runID, testCase, targetType string, labels map[string]string, bps ...float64) {
+		info := iperf3.Info{TestCase: testCase, RunID: runID, TargetType: targetType, CNIName: "cilium", Labels: labels}
+		for i, value := range bps {
+			Expect(db.Create(&iperf3.Metric{
+				Timestamp: start.Add(time.Duration(i) * time.Second), Info: info, BandwidthBps: value, Retransmits: 1,
+				IntervalStart: float64(i), IntervalEnd: float64(i + 1),
+			}).Error).To(Succeed())
+		}
+		Expect(iperf3.StoreLabels(db, &info)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		var err error
+		db, err = gorm.Open(sqlite.Open("file:"+GinkgoT().TempDir()+"/results.db"), &gorm.Config{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(results.Migrate(context.Background(), nil, db)).To(Succeed())
+		store("run-1", "baseline", "pod", map[string]string{"mtu": "1500"}, 8e9, 10e9)
+		store("run-2", "baseline", "cluster-ip", map[string]string{"mtu": "1500"}, 6e9)
+		store("run-3", "wireguard", "pod", map[string]string{"mtu": "1500"}, 4e9, 6e9)
+		store("run-4", "wireguard", "pod", map[string]string{"mtu": "9000"}, 6e9)
+	})
+
+	It("should summarize runs", func() {
+		runs, err := results.Runs(context.Background(), db, results.Filter{TestCase: "baseline"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(runs).To(HaveLen(2))
+		Expect(runs[0]).To(Equal(results.Run{
+			RunID: "run-1", TestCase: "baseline", TargetType: "pod", CNIName: "cilium", StartedAt: start,
+			Intervals: 2, MeanBps: 9e9, MinBps: 8e9, MaxBps: 10e9, Retransmits: 2,
+		}))
+
+		runs, err = results.Runs(context.Background(), db, results.Filter{Labels: map[string]string{"mtu": "9000"}})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(runs).To(HaveLen(1))
+		Expect(runs[0].RunID).To(Equal("run-4"))
+	})
+
+	It("should compare test cases per target type", func() {
+		comparisons, err := results.Compare(context.Background(), db,
+			results.Filter{TestCase: "baseline"}, results.Filter{TestCase: "wireguard"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(comparisons).To(HaveLen(2))
+		Expect(comparisons[0]).To(Equal(results.Comparison{TargetType: "cluster-ip", BaselineRuns: 1, BaselineBps: 6e9}))
+		Expect(comparisons[1]).To(And(
+			HaveField("TargetType", "pod"),
+			HaveField("BaselineRuns", 1), HaveField("BaselineBps", BeNumerically("~", 9e9)),
+			HaveField("CandidateRuns", 2), HaveField("CandidateBps", BeNumerically("~", 5.5e9)),
+			HaveField("ChangePercent", BeNumerically("~", -38.89, 0.01)),
+		))
+	})
+})
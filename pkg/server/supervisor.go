@@ -0,0 +1,213 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"golang.org/x/sync/errgroup"
+
+	config "cni-benchmark/pkg/config"
+	"cni-benchmark/pkg/iperf3"
+	"cni-benchmark/pkg/metrics"
+
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// stableAfter is how long a server has to run for its backoff to reset
+const stableAfter = time.Minute
+
+// probeInterval is how often a started server is dialed until it listens
+const probeInterval = 100 * time.Millisecond
+
+// Supervisor runs a pool of iperf3 servers on consecutive ports and
+// restarts them with backoff when they exit
+type Supervisor struct {
+	cfg     *config.Config
+	servers []*process
+}
+
+// process is the state of a single supervised iperf3 server
+type process struct {
+	port     uint16
+	ready    atomic.Bool
+	restarts atomic.Uint64
+}
+
+// NewSupervisor prepares the pool of servers starting at the configured port
+func NewSupervisor(cfg *config.Config) *Supervisor {
+	s := &Supervisor{cfg: cfg}
+	for i := range cfg.Supervisor.Servers {
+		s.servers = append(s.servers, &process{port: cfg.Port + uint16(i)})
+	}
+	return s
+}
+
+// Run supervises the servers and serves health endpoints until the context
+// is done or serving them fails
+func (s *Supervisor) Run(ctx context.Context) error {
+	var listener net.Listener
+	if len(s.cfg.Supervisor.HealthAddress) > 0 {
+		var err error
+		if listener, err = net.Listen("tcp", s.cfg.Supervisor.HealthAddress); err != nil {
+			return fmt.Errorf("health endpoints failed: %w", err)
+		}
+	}
+	return s.Serve(ctx, listener)
+}
+
+// Serve supervises the servers and serves health endpoints on the listener
+// when it is set. A failure of the endpoints stops the servers and is
+// returned.
+func (s *Supervisor) Serve(ctx context.Context, listener net.Listener) error {
+	log := logf.FromContext(ctx)
+	group, ctx := errgroup.WithContext(ctx)
+	for _, p := range s.servers {
+		group.Go(func() error {
+			s.supervise(ctx, p)
+			return nil
+		})
+	}
+
+	if listener != nil {
+		server := &http.Server{
+			Handler:           s.Handler(),
+			ReadHeaderTimeout: 5 * time.Second,
+		}
+		group.Go(func() error {
+			<-ctx.Done()
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_ = server.Shutdown(shutdownCtx)
+			return nil
+		})
+		group.Go(func() error {
+			log.Info("serving health endpoints", "address", listener.Addr().String())
+			if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+				return fmt.Errorf("health endpoints failed: %w", err)
+			}
+			return nil
+		})
+	}
+	return group.Wait()
+}
+
+// supervise runs the server on its port and restarts it until the context
+// is done, the backoff is reset once the server stays up for a while
+func (s *Supervisor) supervise(ctx context.Context, p *process) {
+	log := logf.FromContext(ctx).WithValues("port", p.port)
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = s.cfg.Supervisor.InitialBackoff
+	b.MaxInterval = s.cfg.Supervisor.MaxBackoff
+	b.MaxElapsedTime = 0
+	cfg := s.cfg.WithPort(p.port)
+
+	for {
+		started := time.Now()
+		err := s.serve(ctx, cfg, p)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) > stableAfter {
+			b.Reset()
+		}
+		delay := b.NextBackOff()
+		p.restarts.Add(1)
+		log.Error(err, "iperf3 server exited, restarting", "delay", delay, "restarts", p.restarts.Load())
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// serve runs a single iperf3 server process until it exits
+func (s *Supervisor) serve(ctx context.Context, cfg *config.Config, p *process) error {
+	cmd := exec.CommandContext(ctx, cfg.Command[0], cfg.Command[1:]...)
+	accepted := metrics.AcceptedConnections.WithLabelValues(strconv.Itoa(int(p.port)))
+	cmd.Stdout = io.MultiWriter(os.Stdout, &iperf3.LineWriter{OnLine: func(line []byte) {
+		// Both the text and the --json output of the server
+		if bytes.Contains(line, []byte("Accepted connection from")) || bytes.Contains(line, []byte(`"accepted_connection"`)) {
+			accepted.Inc()
+		}
+	}})
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start iperf3: %w", err)
+	}
+	exited, probed := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(probed)
+		p.probe(ctx, exited)
+	}()
+	err := cmd.Wait()
+	close(exited)
+	<-probed
+	p.ready.Store(false)
+	if err != nil {
+		return fmt.Errorf("iperf3 failed: %w", err)
+	}
+	return errors.New("iperf3 exited")
+}
+
+// probe dials the server until its port accepts a connection and marks it
+// ready, only that one connection reaches iperf3 per start
+func (p *process) probe(ctx context.Context, exited <-chan struct{}) {
+	address := net.JoinHostPort("localhost", strconv.Itoa(int(p.port)))
+	dialer := &net.Dialer{Timeout: time.Second}
+	ticker := time.NewTicker(probeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-exited:
+			return
+		case <-ticker.C:
+		}
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err == nil {
+			_ = conn.Close()
+			p.ready.Store(true)
+			return
+		}
+	}
+}
+
+// Handler serves /healthz, which succeeds while the supervisor is up, and
+// /readyz, which succeeds when every server of the pool accepts connections
+func (s *Supervisor) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, _ *http.Request) {
+		var body strings.Builder
+		ready := true
+		for _, p := range s.servers {
+			state := "ready"
+			if !p.ready.Load() {
+				state = "starting"
+				ready = false
+			}
+			fmt.Fprintf(&body, "%d: %s, %d restarts\n", p.port, state, p.restarts.Load())
+		}
+		if !ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_, _ = w.Write([]byte(body.String()))
+	})
+	return mux
+}
@@ -0,0 +1,182 @@
+package server_test
+
+import (
+	"cni-benchmark/pkg/config"
+	"cni-benchmark/pkg/metrics"
+	"cni-benchmark/pkg/server"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestServer(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Server")
+}
+
+var _ = Describe("Supervisor", func() {
+	var cfg *config.Config
+	var dir string
+
+	// script stands in for iperf3 and records its arguments on every start
+	script := func(body string) {
+		path := filepath.Join(dir, "iperf3")
+		content := "#!/bin/sh\necho \"$@\" >> " + filepath.Join(dir, "starts") + "\n" + body + "\n"
+		Expect(os.WriteFile(path, []byte(content), 0o700)).To(Succeed())
+		cfg.Command = []string{path, "--server", "--port=5201"}
+	}
+
+	starts := func() []string {
+		data, err := os.ReadFile(filepath.Join(dir, "starts"))
+		if err != nil {
+			return nil
+		}
+		return strings.Split(strings.TrimSpace(string(data)), "\n")
+	}
+
+	status := func(handler http.Handler, path string) int {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		return recorder.Code
+	}
+
+	// listen stands in for the port of the started iperf3 server
+	listen := func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(listener.Close)
+		cfg.Port = uint16(listener.Addr().(*net.TCPAddr).Port)
+	}
+
+	run := func(supervisor *server.Supervisor) {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() { done <- supervisor.Run(ctx) }()
+		DeferCleanup(func() {
+			cancel()
+			Eventually(done).Should(Receive(BeNil()))
+		})
+	}
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+		cfg = &config.Config{
+			Mode: config.ModeServer,
+			Port: 5201,
+			Args: config.Args{"--server": "", "--port": "5201"},
+			Supervisor: config.Supervisor{
+				Servers: 1, InitialBackoff: 10 * time.Millisecond, MaxBackoff: 20 * time.Millisecond,
+			},
+		}
+	})
+
+	It("should restart crashed servers", func() {
+		script("exit 1")
+		supervisor := server.NewSupervisor(cfg)
+		run(supervisor)
+		Eventually(starts).Should(HaveLen(3))
+		Expect(status(supervisor.Handler(), "/healthz")).To(Equal(http.StatusOK))
+	})
+
+	It("should run a pool of servers on consecutive ports", func() {
+		script("exec sleep 60")
+		cfg.Supervisor.Servers = 3
+		run(server.NewSupervisor(cfg))
+		Eventually(starts).Should(ConsistOf(
+			ContainSubstring("--port=5201"), ContainSubstring("--port=5202"), ContainSubstring("--port=5203"),
+		))
+	})
+
+	It("should be ready once the server accepts connections", func() {
+		script("exec sleep 60")
+		listen()
+		supervisor := server.NewSupervisor(cfg)
+		Expect(status(supervisor.Handler(), "/readyz")).To(Equal(http.StatusServiceUnavailable))
+		run(supervisor)
+		Eventually(func() int { return status(supervisor.Handler(), "/readyz") }).Should(Equal(http.StatusOK))
+	})
+
+	It("should not be ready while the server doesn't listen", func() {
+		script("exec sleep 60")
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		// Nothing accepts connections on the freed port
+		cfg.Port = uint16(listener.Addr().(*net.TCPAddr).Port)
+		Expect(listener.Close()).To(Succeed())
+		supervisor := server.NewSupervisor(cfg)
+		run(supervisor)
+		Eventually(starts).Should(HaveLen(1))
+		Consistently(func() int { return status(supervisor.Handler(), "/readyz") }, 300*time.Millisecond).
+			Should(Equal(http.StatusServiceUnavailable))
+	})
+
+	It("should count accepted connections", func() {
+		script("echo 'Accepted connection from 10.0.0.1, port 41234'\nexec sleep 60")
+		cfg.Port = 6201
+		run(server.NewSupervisor(cfg))
+		Eventually(func() float64 {
+			return testutil.ToFloat64(metrics.AcceptedConnections.WithLabelValues("6201"))
+		}).Should(Equal(1.0))
+	})
+
+	It("should serve health endpoints on the listener", func() {
+		script("exec sleep 60")
+		listen()
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		supervisor := server.NewSupervisor(cfg)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() { done <- supervisor.Serve(ctx, listener) }()
+		DeferCleanup(func() {
+			cancel()
+			Eventually(done).Should(Receive(BeNil()))
+		})
+		Eventually(func() (int, error) {
+			request, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://"+listener.Addr().String()+"/readyz", nil)
+			if err != nil {
+				return 0, err
+			}
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				return 0, err
+			}
+			defer response.Body.Close()
+			return response.StatusCode, nil
+		}).Should(Equal(http.StatusOK))
+	})
+
+	It("should fail when the health address is taken", func() {
+		script("exec sleep 60")
+		taken, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(taken.Close)
+		cfg.Supervisor.HealthAddress = taken.Addr().String()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		Expect(server.NewSupervisor(cfg).Run(ctx)).To(MatchError(ContainSubstring("health endpoints failed")))
+		Expect(ctx.Err()).ToNot(HaveOccurred())
+	})
+
+	It("should stop the servers when the health endpoints fail", func() {
+		script("exec sleep 60")
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		// A closed listener makes serving fail right away
+		Expect(listener.Close()).To(Succeed())
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		Expect(server.NewSupervisor(cfg).Serve(ctx, listener)).To(MatchError(ContainSubstring("health endpoints failed")))
+		Expect(ctx.Err()).ToNot(HaveOccurred())
+	})
+})
@@ -0,0 +1,263 @@
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	config "cni-benchmark/pkg/config"
+	"cni-benchmark/pkg/iperf3"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const component = "cni-benchmark"
+
+// Event reasons
+const (
+	ReasonQueued          = "Queued"
+	ReasonStarted         = "Started"
+	ReasonServerReachable = "ServerReachable"
+	ReasonFinished        = "Finished"
+	ReasonFailed          = "Failed"
+)
+
+// Summary is the last result of a test case written to the status ConfigMap
+type Summary struct {
+	RunID       string    `json:"run_id"`
+	TestCase    string    `json:"test_case"`
+	TargetType  string    `json:"target_type,omitempty"`
+	IPFamily    string    `json:"ip_family,omitempty"`
+	Succeeded   bool      `json:"succeeded"`
+	Reason      string    `json:"reason,omitempty"`
+	SentBps     float64   `json:"sent_bps,omitempty"`
+	ReceivedBps float64   `json:"received_bps,omitempty"`
+	Retransmits uint64    `json:"retransmits,omitempty"`
+	FinishedAt  time.Time `json:"finished_at"`
+}
+
+// Completion marks a test case as done so restarted clients don't rerun it
+type Completion struct {
+	TestCase   string    `json:"test_case"`
+	Identity   string    `json:"identity"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// Recorder writes the run progress back to the cluster as Events against
+// the client pod or the Lease, and as a summary ConfigMap
+type Recorder struct {
+	client kubernetes.Interface
+	cfg    *config.Config
+	object corev1.ObjectReference
+}
+
+// NewRecorder picks the object to report against, the client pod when it
+// is known through the downward API and the Lease otherwise. It returns a
+// nil Recorder, which reports nothing, without a client.
+func NewRecorder(ctx context.Context, client kubernetes.Interface, cfg *config.Config) *Recorder {
+	if client == nil {
+		return nil
+	}
+	log := logf.FromContext(ctx)
+	r := &Recorder{client: client, cfg: cfg}
+	if len(cfg.Pod.Name) > 0 && len(cfg.Pod.Namespace) > 0 {
+		pod, err := client.CoreV1().Pods(cfg.Pod.Namespace).Get(ctx, cfg.Pod.Name, metav1.GetOptions{})
+		if err == nil {
+			r.object = corev1.ObjectReference{
+				APIVersion: "v1", Kind: "Pod", Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID,
+			}
+			return r
+		}
+		log.Error(err, "failed to get client pod, reporting against the lease")
+	}
+	r.object = corev1.ObjectReference{
+		APIVersion: "coordination.k8s.io/v1", Kind: "Lease", Namespace: cfg.Lease.Namespace, Name: cfg.Lease.Name,
+	}
+	if lease, err := client.CoordinationV1().Leases(cfg.Lease.Namespace).Get(ctx, cfg.Lease.Name, metav1.GetOptions{}); err == nil {
+		r.object.UID = lease.UID
+	}
+	return r
+}
+
+// Event emits a Kubernetes Event, failures are only logged as the status
+// is informational and must not break the benchmark
+func (r *Recorder) Event(ctx context.Context, eventType, reason, message string) {
+	if r == nil || !r.cfg.Status.Events {
+		return
+	}
+	now := metav1.Now()
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", r.object.Name, now.UnixNano()),
+			Namespace: r.object.Namespace,
+		},
+		InvolvedObject:      r.object,
+		Type:                eventType,
+		Reason:              reason,
+		Message:             message,
+		FirstTimestamp:      now,
+		LastTimestamp:       now,
+		Count:               1,
+		Source:              corev1.EventSource{Component: component, Host: r.cfg.Pod.NodeName},
+		ReportingController: component,
+		ReportingInstance:   r.cfg.Lease.ID,
+	}
+	if _, err := r.client.CoreV1().Events(event.Namespace).Create(ctx, event, metav1.CreateOptions{}); err != nil {
+		logf.FromContext(ctx).Error(err, "failed to emit event", "reason", reason)
+	}
+}
+
+// Queued reports the position of a run waiting in the cluster-wide queue
+func (r *Recorder) Queued(ctx context.Context, info *iperf3.Info, position int) {
+	r.Event(ctx, corev1.EventTypeNormal, ReasonQueued,
+		fmt.Sprintf("Test case %q waits in the queue at position %d%s", info.TestCase, position, target(info)))
+}
+
+// Started reports the beginning of a run
+func (r *Recorder) Started(ctx context.Context, info *iperf3.Info) {
+	r.Event(ctx, corev1.EventTypeNormal, ReasonStarted,
+		fmt.Sprintf("Test case %q run %s started%s", info.TestCase, info.RunID, target(info)))
+}
+
+// ServerReachable reports the server accepts connections
+func (r *Recorder) ServerReachable(ctx context.Context, info *iperf3.Info, address string) {
+	r.Event(ctx, corev1.EventTypeNormal, ReasonServerReachable,
+		fmt.Sprintf("Server %s is reachable%s", address, target(info)))
+}
+
+// Finished reports a successful run and records its summary
+func (r *Recorder) Finished(ctx context.Context, info *iperf3.Info, report *iperf3.Report) {
+	summary := r.summary(info)
+	summary.Succeeded = true
+	message := fmt.Sprintf("Test case %q run %s finished%s", info.TestCase, info.RunID, target(info))
+	if report != nil {
+		summary.SentBps = report.End.Sent.BitsPerSecond
+		summary.ReceivedBps = report.End.Received.BitsPerSecond
+		summary.Retransmits = report.End.Sent.Retransmits
+		message += fmt.Sprintf(": sent %.2f Mbit/s, received %.2f Mbit/s, %d retransmits",
+			summary.SentBps/1e6, summary.ReceivedBps/1e6, summary.Retransmits)
+	}
+	r.Event(ctx, corev1.EventTypeNormal, ReasonFinished, message)
+	r.Summary(ctx, summary)
+}
+
+// Failed reports a failed run and records the reason in its summary
+func (r *Recorder) Failed(ctx context.Context, info *iperf3.Info, err error) {
+	summary := r.summary(info)
+	summary.Reason = err.Error()
+	r.Event(ctx, corev1.EventTypeWarning, ReasonFailed,
+		fmt.Sprintf("Test case %q run %s failed%s: %s", info.TestCase, info.RunID, target(info), err))
+	r.Summary(ctx, summary)
+}
+
+// Summary writes the summary under a key named after the test case and the
+// target type into the status ConfigMap in the Lease namespace. Dual-stack
+// runs keep a summary per IP family.
+func (r *Recorder) Summary(ctx context.Context, summary Summary) {
+	if r == nil || len(r.cfg.Status.ConfigMap) == 0 {
+		return
+	}
+	key := summary.TestCase + "." + summary.TargetType
+	if r.cfg.IPFamily == config.IPFamilyDual && len(summary.IPFamily) > 0 {
+		key += "." + summary.IPFamily
+	}
+	key = config.ConfigMapKey(key)
+	if err := r.set(ctx, key, summary); err != nil {
+		logf.FromContext(ctx).Error(err, "failed to write run summary", "configmap", r.cfg.Status.ConfigMap, "key", key)
+	}
+}
+
+// Completed returns the completion marker of the test case, nil when the
+// test case has not completed yet or the status ConfigMap is disabled
+func (r *Recorder) Completed(ctx context.Context, testCase string) (*Completion, error) {
+	if r == nil || len(r.cfg.Status.ConfigMap) == 0 {
+		return nil, nil
+	}
+	cm, err := r.client.CoreV1().ConfigMaps(r.cfg.Lease.Namespace).Get(ctx, r.cfg.Status.ConfigMap, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get status configmap: %w", err)
+	}
+	value, ok := cm.Data[completionKey(testCase)]
+	if !ok {
+		return nil, nil
+	}
+	completion := &Completion{}
+	if err = json.Unmarshal([]byte(value), completion); err != nil {
+		return nil, fmt.Errorf("failed to decode completion marker: %w", err)
+	}
+	return completion, nil
+}
+
+// MarkCompleted records the test case has completed so later clients skip it
+func (r *Recorder) MarkCompleted(ctx context.Context, testCase string) error {
+	if r == nil || len(r.cfg.Status.ConfigMap) == 0 {
+		return nil
+	}
+	completion := Completion{TestCase: testCase, Identity: r.cfg.Lease.ID, FinishedAt: time.Now().UTC()}
+	if err := r.set(ctx, completionKey(testCase), completion); err != nil {
+		return fmt.Errorf("failed to record completion: %w", err)
+	}
+	return nil
+}
+
+// set stores the value as JSON under the key of the status ConfigMap,
+// creating it on the first write
+func (r *Recorder) set(ctx context.Context, key string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	configMaps := r.client.CoreV1().ConfigMaps(r.cfg.Lease.Namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := configMaps.Get(ctx, r.cfg.Status.ConfigMap, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			cm = &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+				Name:      r.cfg.Status.ConfigMap,
+				Namespace: r.cfg.Lease.Namespace,
+				Labels:    map[string]string{"app.kubernetes.io/managed-by": component},
+			}, Data: map[string]string{key: string(data)}}
+			_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		cm.Data[key] = string(data)
+		_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func (r *Recorder) summary(info *iperf3.Info) Summary {
+	return Summary{
+		RunID:      info.RunID,
+		TestCase:   info.TestCase,
+		TargetType: info.TargetType,
+		IPFamily:   info.IPFamily,
+		FinishedAt: time.Now().UTC(),
+	}
+}
+
+func target(info *iperf3.Info) string {
+	if len(info.TargetType) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" against %s target", info.TargetType)
+}
+
+// completionKey is prefixed not to collide with summaries of the test case
+func completionKey(testCase string) string {
+	return config.ConfigMapKey("completed." + testCase)
+}
@@ -0,0 +1,162 @@
+package status_test
+
+import (
+	"cni-benchmark/pkg/config"
+	"cni-benchmark/pkg/iperf3"
+	"cni-benchmark/pkg/status"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestStatus(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Status")
+}
+
+var _ = Describe("Recorder", func() {
+	var client *fake.Clientset
+	var cfg *config.Config
+	ctx := context.Background()
+	info := &iperf3.Info{TestCase: "01-p2p tcp", TargetType: "cluster-ip", RunID: "run"}
+
+	events := func() []corev1.Event {
+		list, err := client.CoreV1().Events("bench").List(ctx, metav1.ListOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		return list.Items
+	}
+
+	summary := func(key string) (s status.Summary) {
+		cm, err := client.CoreV1().ConfigMaps("bench").Get(ctx, "cni-benchmark-status", metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cm.Data).To(HaveKey(key))
+		Expect(json.Unmarshal([]byte(cm.Data[key]), &s)).To(Succeed())
+		return
+	}
+
+	BeforeEach(func() {
+		client = fake.NewClientset(
+			&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "client", Namespace: "bench", UID: "pod-uid"}},
+			&coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Name: "cni-benchmark", Namespace: "bench", UID: "lease-uid"}},
+		)
+		cfg = &config.Config{
+			Lease:  config.Lease{Namespace: "bench", Name: "cni-benchmark", ID: "client-1"},
+			Pod:    config.Pod{Name: "client", Namespace: "bench"},
+			Status: config.Status{Events: true, ConfigMap: "cni-benchmark-status"},
+		}
+	})
+
+	It("should report a finished run against the client pod", func() {
+		recorder := status.NewRecorder(ctx, client, cfg)
+		recorder.Started(ctx, info)
+		recorder.ServerReachable(ctx, info, "10.96.0.10")
+		report := &iperf3.Report{}
+		report.End.Sent.BitsPerSecond = 9.5e9
+		report.End.Received.BitsPerSecond = 9.4e9
+		report.End.Sent.Retransmits = 3
+		recorder.Finished(ctx, info, report)
+
+		list := events()
+		Expect(list).To(HaveLen(3))
+		for _, event := range list {
+			Expect(event.InvolvedObject.Kind).To(Equal("Pod"))
+			Expect(event.InvolvedObject.UID).To(BeEquivalentTo("pod-uid"))
+			Expect(event.Type).To(Equal(corev1.EventTypeNormal))
+		}
+		Expect(list).To(ContainElement(And(
+			HaveField("Reason", status.ReasonFinished),
+			HaveField("Message", ContainSubstring("sent 9500.00 Mbit/s")),
+		)))
+
+		s := summary("01-p2p_tcp.cluster-ip")
+		Expect(s.Succeeded).To(BeTrue())
+		Expect(s.RunID).To(Equal("run"))
+		Expect(s.ReceivedBps).To(Equal(9.4e9))
+		Expect(s.Retransmits).To(Equal(uint64(3)))
+	})
+
+	It("should report a failed run against the lease and keep other summaries", func() {
+		cfg.Pod = config.Pod{}
+		recorder := status.NewRecorder(ctx, client, cfg)
+		recorder.Finished(ctx, &iperf3.Info{TestCase: "dns", RunID: "dns-run"}, nil)
+		recorder.Failed(ctx, info, errors.New("connection refused"))
+
+		Expect(events()).To(ContainElement(And(
+			HaveField("Type", corev1.EventTypeWarning),
+			HaveField("Reason", status.ReasonFailed),
+			HaveField("InvolvedObject.Kind", "Lease"),
+			HaveField("InvolvedObject.UID", BeEquivalentTo("lease-uid")),
+		)))
+		Expect(summary("dns").Succeeded).To(BeTrue())
+		s := summary("01-p2p_tcp.cluster-ip")
+		Expect(s.Succeeded).To(BeFalse())
+		Expect(s.Reason).To(Equal("connection refused"))
+	})
+
+	It("should keep a summary per IP family of dual-stack runs", func() {
+		cfg.IPFamily = config.IPFamilyDual
+		recorder := status.NewRecorder(ctx, client, cfg)
+		ipv4, ipv6 := *info, *info
+		ipv4.IPFamily, ipv6.IPFamily = string(config.IPFamilyIPv4), string(config.IPFamilyIPv6)
+		recorder.Finished(ctx, &ipv4, nil)
+		recorder.Failed(ctx, &ipv6, errors.New("network unreachable"))
+
+		Expect(summary("01-p2p_tcp.cluster-ip.ipv4").Succeeded).To(BeTrue())
+		s := summary("01-p2p_tcp.cluster-ip.ipv6")
+		Expect(s.Succeeded).To(BeFalse())
+		Expect(s.IPFamily).To(Equal("ipv6"))
+	})
+
+	It("should respect disabled events and summaries", func() {
+		cfg.Status = config.Status{}
+		recorder := status.NewRecorder(ctx, client, cfg)
+		recorder.Failed(ctx, info, errors.New("connection refused"))
+		Expect(events()).To(BeEmpty())
+		_, err := client.CoreV1().ConfigMaps("bench").Get(ctx, "cni-benchmark-status", metav1.GetOptions{})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should tolerate a nil recorder", func() {
+		var recorder *status.Recorder
+		recorder.Started(ctx, info)
+		recorder.Finished(ctx, info, nil)
+	})
+
+	Context("Completion", func() {
+		It("should record and find completed test cases", func() {
+			recorder := status.NewRecorder(ctx, client, cfg)
+			completion, err := recorder.Completed(ctx, "01-p2p tcp")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(completion).To(BeNil())
+
+			recorder.Finished(ctx, info, nil)
+			Expect(recorder.MarkCompleted(ctx, "01-p2p tcp")).To(Succeed())
+			completion, err = recorder.Completed(ctx, "01-p2p tcp")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(completion).ToNot(BeNil())
+			Expect(completion.Identity).To(Equal("client-1"))
+			Expect(summary("01-p2p_tcp.cluster-ip").Succeeded).To(BeTrue())
+
+			completion, err = recorder.Completed(ctx, "02-p2s-udp")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(completion).To(BeNil())
+		})
+
+		It("should not record completion without the status ConfigMap", func() {
+			cfg.Status.ConfigMap = ""
+			recorder := status.NewRecorder(ctx, client, cfg)
+			Expect(recorder.MarkCompleted(ctx, "01-p2p tcp")).To(Succeed())
+			completion, err := recorder.Completed(ctx, "01-p2p tcp")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(completion).To(BeNil())
+		})
+	})
+})
@@ -0,0 +1,214 @@
+package target
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	config "cni-benchmark/pkg/config"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Server is a ready server instance found through the Kubernetes API
+type Server struct {
+	Address  config.Address
+	PodName  string
+	PodIP    string
+	NodeName string
+	// Addresses of every IP family, the primary one first
+	Addresses []config.Address
+	// Port iperf3 listens on in the pod
+	Port uint16
+}
+
+// Discover watches Pods or EndpointSlices referenced by the server address
+// until a ready server with an address of the IP family appears. Dual-stack
+// servers need both an IPv4 and an IPv6 address. Servers behind a Service
+// listen on the port of their EndpointSlice serving the iperf3 port.
+func Discover(ctx context.Context, client kubernetes.Interface, address config.Address, family config.IPFamily, port uint16) (*Server, error) {
+	log := logf.FromContext(ctx)
+	kind, namespace, value, err := address.Reference()
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return nil, errors.New("kubernetes client is required to discover the server")
+	}
+
+	var selector labels.Selector
+	var object runtime.Object
+	var lw cache.ListWatch
+	var pick func(watch.Event) *Server
+	switch kind {
+	case config.ReferencePod:
+		if selector, err = labels.Parse(value); err != nil {
+			return nil, fmt.Errorf("invalid pod selector: %w", err)
+		}
+		pods := client.CoreV1().Pods(namespace)
+		object = &corev1.Pod{}
+		lw.ListFunc = func(o metav1.ListOptions) (runtime.Object, error) {
+			o.LabelSelector = selector.String()
+			return pods.List(ctx, o)
+		}
+		lw.WatchFunc = func(o metav1.ListOptions) (watch.Interface, error) {
+			o.LabelSelector = selector.String()
+			return pods.Watch(ctx, o)
+		}
+		pick = func(event watch.Event) *Server {
+			pod, ok := event.Object.(*corev1.Pod)
+			if !ok || event.Type == watch.Deleted || !selector.Matches(labels.Set(pod.Labels)) || !IsPodReady(pod) {
+				return nil
+			}
+			podIPs := make([]string, 0, len(pod.Status.PodIPs))
+			for _, ip := range pod.Status.PodIPs {
+				podIPs = append(podIPs, ip.IP)
+			}
+			server := &Server{config.Address(pod.Status.PodIP), pod.Name, pod.Status.PodIP, pod.Spec.NodeName,
+				addresses(pod.Status.PodIP, podIPs), port}
+			if !covers(server.Addresses, family) {
+				return nil
+			}
+			return server
+		}
+	case config.ReferenceService:
+		selector = labels.SelectorFromSet(labels.Set{discoveryv1.LabelServiceName: value})
+		slices := client.DiscoveryV1().EndpointSlices(namespace)
+		object = &discoveryv1.EndpointSlice{}
+		lw.ListFunc = func(o metav1.ListOptions) (runtime.Object, error) {
+			o.LabelSelector = selector.String()
+			return slices.List(ctx, o)
+		}
+		lw.WatchFunc = func(o metav1.ListOptions) (watch.Interface, error) {
+			o.LabelSelector = selector.String()
+			return slices.Watch(ctx, o)
+		}
+		// Slices name their ports after the Service ports
+		var portName string
+		if service, err := client.CoreV1().Services(namespace).Get(ctx, value, metav1.GetOptions{}); err == nil {
+			if servicePort, err := servicePort(service, port); err == nil {
+				portName = servicePort.Name
+			}
+		}
+		// Slices hold a single address family, the server is picked across
+		// all slices of the Service
+		known := map[string]*discoveryv1.EndpointSlice{}
+		pick = func(event watch.Event) *Server {
+			slice, ok := event.Object.(*discoveryv1.EndpointSlice)
+			if !ok || !selector.Matches(labels.Set(slice.Labels)) {
+				return nil
+			}
+			if event.Type == watch.Deleted {
+				delete(known, slice.Name)
+				return nil
+			}
+			known[slice.Name] = slice
+			return readyEndpoint(known, family, portName, port)
+		}
+	default:
+		return nil, fmt.Errorf("server %s is not a kubernetes reference", address)
+	}
+
+	log.Info("waiting for a ready server", "kind", kind, "namespace", namespace, "selector", selector.String())
+	var server *Server
+	_, err = watchtools.UntilWithSync(ctx, &lw, object, nil, func(event watch.Event) (bool, error) {
+		server = pick(event)
+		return server != nil, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed waiting for a ready server: %w", err)
+	}
+	log.Info("found a ready server", "pod", server.PodName, "ip", server.PodIP, "node", server.NodeName)
+	return server, nil
+}
+
+// readyEndpoint returns the first ready endpoint with addresses of the IP
+// family. Endpoints of the same pod are merged across the slices.
+func readyEndpoint(known map[string]*discoveryv1.EndpointSlice, family config.IPFamily, portName string, port uint16) *Server {
+	var servers []*Server
+	byKey := map[string]*Server{}
+	for _, name := range slices.Sorted(maps.Keys(known)) {
+		for _, endpoint := range known[name].Endpoints {
+			if len(endpoint.Addresses) == 0 || (endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready) {
+				continue
+			}
+			key := endpoint.Addresses[0]
+			if endpoint.TargetRef != nil && endpoint.TargetRef.Kind == "Pod" {
+				key = endpoint.TargetRef.Namespace + "/" + endpoint.TargetRef.Name
+			}
+			server, ok := byKey[key]
+			if !ok {
+				server = &Server{Address: config.Address(endpoint.Addresses[0]), PodIP: endpoint.Addresses[0],
+					Port: endpointPort(known[name], portName, port)}
+				if endpoint.NodeName != nil {
+					server.NodeName = *endpoint.NodeName
+				}
+				if endpoint.TargetRef != nil && endpoint.TargetRef.Kind == "Pod" {
+					server.PodName = endpoint.TargetRef.Name
+				}
+				byKey[key] = server
+				servers = append(servers, server)
+			}
+			server.Addresses = append(server.Addresses, config.Address(endpoint.Addresses[0]))
+		}
+	}
+	for _, server := range servers {
+		if covers(server.Addresses, family) {
+			return server
+		}
+	}
+	return nil
+}
+
+// endpointPort returns the slice port serving the iperf3 port: the port
+// itself, the one named after its Service port, or the first one
+func endpointPort(slice *discoveryv1.EndpointSlice, name string, port uint16) uint16 {
+	var named, first *int32
+	for _, p := range slice.Ports {
+		if p.Port == nil || (p.Protocol != nil && *p.Protocol != corev1.ProtocolTCP) {
+			continue
+		}
+		switch {
+		case *p.Port == int32(port):
+			return port
+		case p.Name != nil && *p.Name == name && named == nil:
+			named = p.Port
+		case first == nil:
+			first = p.Port
+		}
+	}
+	if named != nil {
+		return uint16(*named)
+	}
+	if first != nil {
+		return uint16(*first)
+	}
+	return port
+}
+
+// covers tells whether the addresses reach the IP family, dual needs both
+func covers(addresses []config.Address, family config.IPFamily) bool {
+	has := map[config.IPFamily]bool{}
+	for _, address := range addresses {
+		has[address.Family()] = true
+	}
+	switch family {
+	case config.IPFamilyAny:
+		return len(addresses) > 0
+	case config.IPFamilyDual:
+		return has[config.IPFamilyIPv4] && has[config.IPFamilyIPv6]
+	default:
+		return has[family]
+	}
+}
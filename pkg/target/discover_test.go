@@ -0,0 +1,173 @@
+package target_test
+
+import (
+	"cni-benchmark/pkg/config"
+	"cni-benchmark/pkg/target"
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+)
+
+var _ = Describe("Discover", func() {
+	var client *fake.Clientset
+	var ctx context.Context
+	var cancel context.CancelFunc
+
+	BeforeEach(func() {
+		client = fake.NewClientset()
+		ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("should wait for a ready pod matching the selector", func() {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "server", Namespace: "bench", Labels: map[string]string{"app": "server"}},
+			Spec:       corev1.PodSpec{NodeName: "worker-1"},
+			Status:     corev1.PodStatus{Phase: corev1.PodPending},
+		}
+		_, err := client.CoreV1().Pods("bench").Create(ctx, pod, metav1.CreateOptions{})
+		Expect(err).ToNot(HaveOccurred())
+
+		go func() {
+			defer GinkgoRecover()
+			time.Sleep(200 * time.Millisecond)
+			ready := pod.DeepCopy()
+			ready.Status = corev1.PodStatus{
+				Phase:      corev1.PodRunning,
+				PodIP:      "10.244.1.7",
+				Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+			}
+			_, err := client.CoreV1().Pods("bench").UpdateStatus(ctx, ready, metav1.UpdateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+		}()
+
+		server, err := target.Discover(ctx, client, "pod:bench/app=server", config.IPFamilyAny, 5201)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(server).To(Equal(&target.Server{
+			Address: "10.244.1.7", PodName: "server", PodIP: "10.244.1.7", NodeName: "worker-1",
+			Addresses: []config.Address{"10.244.1.7"}, Port: 5201,
+		}))
+	})
+
+	It("should pick a ready endpoint of the service", func() {
+		slice := &discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "server-abc", Namespace: "bench",
+				Labels: map[string]string{discoveryv1.LabelServiceName: "server"},
+			},
+			AddressType: discoveryv1.AddressTypeIPv4,
+			Ports: []discoveryv1.EndpointPort{
+				{Name: ptr.To("metrics"), Port: ptr.To[int32](9090)},
+				{Name: ptr.To("iperf3"), Port: ptr.To[int32](5202)},
+			},
+			Endpoints: []discoveryv1.Endpoint{
+				{Addresses: []string{"10.244.2.1"}, Conditions: discoveryv1.EndpointConditions{Ready: ptr.To(false)}},
+				{
+					Addresses:  []string{"10.244.2.2"},
+					Conditions: discoveryv1.EndpointConditions{Ready: ptr.To(true)},
+					NodeName:   ptr.To("worker-2"),
+					TargetRef:  &corev1.ObjectReference{Kind: "Pod", Name: "server-2"},
+				},
+			},
+		}
+		_, err := client.DiscoveryV1().EndpointSlices("bench").Create(ctx, slice, metav1.CreateOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		_, err = client.CoreV1().Services("bench").Create(ctx, &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "server", Namespace: "bench"},
+			Spec: corev1.ServiceSpec{Ports: []corev1.ServicePort{
+				{Name: "metrics", Port: 9090},
+				{Name: "iperf3", Port: 80, TargetPort: intstr.FromString("iperf3")},
+			}},
+		}, metav1.CreateOptions{})
+		Expect(err).ToNot(HaveOccurred())
+
+		server, err := target.Discover(ctx, client, "service:bench/server", config.IPFamilyAny, 80)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(server).To(Equal(&target.Server{
+			Address: "10.244.2.2", PodName: "server-2", PodIP: "10.244.2.2", NodeName: "worker-2",
+			Addresses: []config.Address{"10.244.2.2"}, Port: 5202,
+		}))
+	})
+
+	It("should carry every IP family of a dual-stack pod", func() {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "server", Namespace: "bench", Labels: map[string]string{"app": "server"}},
+			Spec:       corev1.PodSpec{NodeName: "worker-1"},
+			Status: corev1.PodStatus{
+				Phase:      corev1.PodRunning,
+				PodIP:      "10.244.1.7",
+				PodIPs:     []corev1.PodIP{{IP: "10.244.1.7"}, {IP: "fd00:10:244::7"}},
+				Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+			},
+		}
+		_, err := client.CoreV1().Pods("bench").Create(ctx, pod, metav1.CreateOptions{})
+		Expect(err).ToNot(HaveOccurred())
+
+		server, err := target.Discover(ctx, client, "pod:bench/app=server", config.IPFamilyDual, 5201)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(server.Addresses).To(Equal([]config.Address{"10.244.1.7", "fd00:10:244::7"}))
+	})
+
+	It("should merge the endpoint slices of each IP family", func() {
+		endpoint := func(address string) discoveryv1.Endpoint {
+			return discoveryv1.Endpoint{
+				Addresses:  []string{address},
+				Conditions: discoveryv1.EndpointConditions{Ready: ptr.To(true)},
+				NodeName:   ptr.To("worker-2"),
+				TargetRef:  &corev1.ObjectReference{Kind: "Pod", Namespace: "bench", Name: "server-2"},
+			}
+		}
+		slice := func(name string, addressType discoveryv1.AddressType, address string) *discoveryv1.EndpointSlice {
+			return &discoveryv1.EndpointSlice{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: name, Namespace: "bench",
+					Labels: map[string]string{discoveryv1.LabelServiceName: "server"},
+				},
+				AddressType: addressType,
+				Endpoints:   []discoveryv1.Endpoint{endpoint(address)},
+			}
+		}
+		_, err := client.DiscoveryV1().EndpointSlices("bench").Create(ctx, slice("server-v4", discoveryv1.AddressTypeIPv4, "10.244.2.2"), metav1.CreateOptions{})
+		Expect(err).ToNot(HaveOccurred())
+
+		// Only the IPv4 slice exists, a dual-stack server is not there yet
+		short, cancelShort := context.WithTimeout(ctx, 200*time.Millisecond)
+		defer cancelShort()
+		_, err = target.Discover(short, client, "service:bench/server", config.IPFamilyDual, 5201)
+		Expect(err).To(HaveOccurred())
+
+		_, err = client.DiscoveryV1().EndpointSlices("bench").Create(ctx, slice("server-v6", discoveryv1.AddressTypeIPv6, "fd00:10:244::2"), metav1.CreateOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		server, err := target.Discover(ctx, client, "service:bench/server", config.IPFamilyDual, 5201)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(server).To(Equal(&target.Server{
+			Address: "10.244.2.2", PodName: "server-2", PodIP: "10.244.2.2", NodeName: "worker-2",
+			Addresses: []config.Address{"10.244.2.2", "fd00:10:244::2"}, Port: 5201,
+		}))
+
+		server, err = target.Discover(ctx, client, "service:bench/server", config.IPFamilyIPv6, 5201)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(server.Addresses).To(ContainElement(config.Address("fd00:10:244::2")))
+	})
+
+	It("should fail for literal addresses and on timeout", func() {
+		_, err := target.Discover(ctx, client, "example.com", config.IPFamilyAny, 5201)
+		Expect(err).To(HaveOccurred())
+
+		short, cancelShort := context.WithTimeout(ctx, 200*time.Millisecond)
+		defer cancelShort()
+		_, err = target.Discover(short, client, "service:bench/missing", config.IPFamilyAny, 5201)
+		Expect(err).To(HaveOccurred())
+	})
+})
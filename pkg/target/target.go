@@ -0,0 +1,163 @@
+package target
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	config "cni-benchmark/pkg/config"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Target is a single way to reach the server
+type Target struct {
+	Type    config.TargetType
+	Address config.Address
+	Port    uint16
+	// Server pod behind the target when it is the only one it can reach
+	Server *Server
+	// Addresses of every IP family on dual-stack clusters, empty for names
+	Addresses []config.Address
+}
+
+// ForFamily returns the target reaching the server over the IP family, false
+// when it has no address of that family. Hostnames are left to the resolver.
+func (t Target) ForFamily(family config.IPFamily) (Target, bool) {
+	if family == config.IPFamilyAny || family == config.IPFamilyDual || t.Address.Family() == config.IPFamilyAny {
+		return t, true
+	}
+	for _, address := range append([]config.Address{t.Address}, t.Addresses...) {
+		if address.Family() == family {
+			t.Address = address
+			return t, true
+		}
+	}
+	return t, false
+}
+
+// Resolve looks up the server Service and returns configured targets in order
+func Resolve(ctx context.Context, client kubernetes.Interface, cfg *config.Config) (targets []Target, err error) {
+	namespace, name, err := cfg.Targets.ServiceRef()
+	if err != nil {
+		return nil, err
+	}
+	service, err := client.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get server service: %w", err)
+	}
+	port, err := servicePort(service, cfg.Port)
+	if err != nil {
+		return nil, err
+	}
+	targetPort := uint16(port.TargetPort.IntValue())
+	if targetPort == 0 {
+		targetPort = cfg.Port
+	}
+
+	pod, err := readyPod(ctx, client, service)
+	if err != nil {
+		return nil, err
+	}
+	podIPs := make([]string, 0, len(pod.Status.PodIPs))
+	for _, ip := range pod.Status.PodIPs {
+		podIPs = append(podIPs, ip.IP)
+	}
+	// Only the pod target is known to reach this pod, the Service may pick
+	// another backend so the other targets carry no server placement
+	server := &Server{config.Address(pod.Status.PodIP), pod.Name, pod.Status.PodIP, pod.Spec.NodeName,
+		addresses(pod.Status.PodIP, podIPs), targetPort}
+	hostIPs := make([]string, 0, len(pod.Status.HostIPs))
+	for _, ip := range pod.Status.HostIPs {
+		hostIPs = append(hostIPs, ip.IP)
+	}
+	for _, targetType := range cfg.Targets.Types {
+		switch targetType {
+		case config.TargetPod:
+			targets = append(targets, Target{targetType, server.Address, targetPort, server, server.Addresses})
+		case config.TargetClusterIP:
+			if service.Spec.ClusterIP == "" || service.Spec.ClusterIP == corev1.ClusterIPNone {
+				return nil, fmt.Errorf("service %s/%s has no cluster IP", namespace, name)
+			}
+			targets = append(targets, Target{targetType, config.Address(service.Spec.ClusterIP), uint16(port.Port), nil,
+				addresses(service.Spec.ClusterIP, service.Spec.ClusterIPs)})
+		case config.TargetNodePort:
+			if port.NodePort == 0 {
+				return nil, fmt.Errorf("service %s/%s has no node port", namespace, name)
+			}
+			targets = append(targets, Target{targetType, config.Address(pod.Status.HostIP), uint16(port.NodePort), nil,
+				addresses(pod.Status.HostIP, hostIPs)})
+		case config.TargetHeadless:
+			headless := cfg.Targets.HeadlessService
+			if len(headless) == 0 {
+				headless = name
+			}
+			fqdn := fmt.Sprintf("%s.%s.svc.%s", headless, namespace, cfg.Targets.ClusterDomain)
+			targets = append(targets, Target{targetType, config.Address(fqdn), targetPort, nil, nil})
+		default:
+			return nil, fmt.Errorf("unsupported target: %s", targetType)
+		}
+	}
+	return
+}
+
+// addresses lists the primary IP followed by the other families, older
+// clusters only fill the primary one
+func addresses(primary string, all []string) []config.Address {
+	result := []config.Address{config.Address(primary)}
+	for _, ip := range all {
+		if ip != primary {
+			result = append(result, config.Address(ip))
+		}
+	}
+	return result
+}
+
+// servicePort finds the Service port pointing to the iperf3 port or the first one
+func servicePort(service *corev1.Service, port uint16) (*corev1.ServicePort, error) {
+	if len(service.Spec.Ports) == 0 {
+		return nil, fmt.Errorf("service %s/%s has no ports", service.Namespace, service.Name)
+	}
+	for i := range service.Spec.Ports {
+		p := &service.Spec.Ports[i]
+		if p.TargetPort.IntValue() == int(port) || p.Port == int32(port) {
+			return p, nil
+		}
+	}
+	return &service.Spec.Ports[0], nil
+}
+
+// readyPod returns the first ready pod selected by the Service
+func readyPod(ctx context.Context, client kubernetes.Interface, service *corev1.Service) (*corev1.Pod, error) {
+	if len(service.Spec.Selector) == 0 {
+		return nil, errors.New("server service has no selector")
+	}
+	pods, err := client.CoreV1().Pods(service.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(service.Spec.Selector).String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list server pods: %w", err)
+	}
+	for i := range pods.Items {
+		if IsPodReady(&pods.Items[i]) {
+			return &pods.Items[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no ready server pods for service %s/%s", service.Namespace, service.Name)
+}
+
+// IsPodReady reports whether the pod has an IP and passes readiness
+func IsPodReady(pod *corev1.Pod) bool {
+	if pod.Status.Phase != corev1.PodRunning || len(pod.Status.PodIP) == 0 || pod.DeletionTimestamp != nil {
+		return false
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package target_test
+
+import (
+	"cni-benchmark/pkg/config"
+	"cni-benchmark/pkg/target"
+	"context"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestTarget(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Target")
+}
+
+var _ = Describe("Resolve", func() {
+	var client *fake.Clientset
+	var cfg *config.Config
+
+	BeforeEach(func() {
+		labels := map[string]string{"app": "iperf3-server"}
+		client = fake.NewClientset(
+			&corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "server", Namespace: "bench"},
+				Spec: corev1.ServiceSpec{
+					Type:      corev1.ServiceTypeNodePort,
+					ClusterIP: "10.96.0.42",
+					Selector:  labels,
+					Ports: []corev1.ServicePort{
+						{Name: "metrics", Port: 9090, TargetPort: intstr.FromInt32(9090)},
+						{Name: "iperf3", Port: 80, TargetPort: intstr.FromInt32(5201), NodePort: 30201},
+					},
+				},
+			},
+			&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "pending", Namespace: "bench", Labels: labels},
+				Status:     corev1.PodStatus{Phase: corev1.PodPending},
+			},
+			&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "ready", Namespace: "bench", Labels: labels},
+				Spec:       corev1.PodSpec{NodeName: "worker-1"},
+				Status: corev1.PodStatus{
+					Phase:      corev1.PodRunning,
+					PodIP:      "10.244.1.5",
+					HostIP:     "172.18.0.3",
+					Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+				},
+			},
+		)
+		cfg = &config.Config{
+			Port: 5201,
+			Targets: config.Targets{
+				Service:         "bench/server",
+				HeadlessService: "server-headless",
+				ClusterDomain:   "cluster.local",
+				Types: []config.TargetType{
+					config.TargetPod, config.TargetClusterIP, config.TargetNodePort, config.TargetHeadless,
+				},
+			},
+		}
+	})
+
+	It("should resolve all target types in order", func() {
+		targets, err := target.Resolve(context.Background(), client, cfg)
+		Expect(err).ToNot(HaveOccurred())
+		server := &target.Server{Address: "10.244.1.5", PodName: "ready", PodIP: "10.244.1.5", NodeName: "worker-1", Addresses: []config.Address{"10.244.1.5"}, Port: 5201}
+		Expect(targets).To(Equal([]target.Target{
+			{Type: config.TargetPod, Address: "10.244.1.5", Port: 5201, Server: server, Addresses: []config.Address{"10.244.1.5"}},
+			{Type: config.TargetClusterIP, Address: "10.96.0.42", Port: 80, Addresses: []config.Address{"10.96.0.42"}},
+			{Type: config.TargetNodePort, Address: "172.18.0.3", Port: 30201, Addresses: []config.Address{"172.18.0.3"}},
+			{Type: config.TargetHeadless, Address: "server-headless.bench.svc.cluster.local", Port: 5201},
+		}))
+	})
+
+	It("should pick the address of each family on dual-stack clusters", func() {
+		service, err := client.CoreV1().Services("bench").Get(context.Background(), "server", metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		service.Spec.ClusterIPs = []string{"10.96.0.42", "fd00:10:96::42"}
+		_, err = client.CoreV1().Services("bench").Update(context.Background(), service, metav1.UpdateOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		cfg.Targets.Types = []config.TargetType{config.TargetClusterIP, config.TargetHeadless}
+
+		targets, err := target.Resolve(context.Background(), client, cfg)
+		Expect(err).ToNot(HaveOccurred())
+		v6, ok := targets[0].ForFamily(config.IPFamilyIPv6)
+		Expect(ok).To(BeTrue())
+		Expect(v6.Address).To(Equal(config.Address("fd00:10:96::42")))
+		v4, ok := targets[0].ForFamily(config.IPFamilyIPv4)
+		Expect(ok).To(BeTrue())
+		Expect(v4.Address).To(Equal(config.Address("10.96.0.42")))
+		headless, ok := targets[1].ForFamily(config.IPFamilyIPv6)
+		Expect(ok).To(BeTrue())
+		Expect(headless.Address).To(Equal(targets[1].Address))
+	})
+
+	It("should report a missing family", func() {
+		single := target.Target{Address: "10.96.0.42", Addresses: []config.Address{"10.96.0.42"}}
+		_, ok := single.ForFamily(config.IPFamilyIPv6)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should fail when the service is missing", func() {
+		cfg.Targets.Service = "bench/missing"
+		_, err := target.Resolve(context.Background(), client, cfg)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should fail when no server pod is ready", func() {
+		Expect(client.CoreV1().Pods("bench").Delete(context.Background(), "ready", metav1.DeleteOptions{})).To(Succeed())
+		_, err := target.Resolve(context.Background(), client, cfg)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,85 @@
+package tracing_test
+
+import (
+	"cni-benchmark/pkg/config"
+	"cni-benchmark/pkg/iperf3"
+	"cni-benchmark/pkg/tracing"
+	"cni-benchmark/test/utils"
+	"context"
+	"errors"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel/trace"
+	tracev1 "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+func TestTracing(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Tracing")
+}
+
+var _ = Describe("Tracing", func() {
+	var receiver *utils.OTLPReceiver
+
+	BeforeEach(func() {
+		receiver = utils.StartOTLPReceiver()
+		DeferCleanup(receiver.Close)
+	})
+
+	attributes := func(span *tracev1.Span) map[string]string {
+		values := map[string]string{}
+		for _, attribute := range span.GetAttributes() {
+			values[attribute.GetKey()] = attribute.GetValue().GetStringValue()
+		}
+		return values
+	}
+
+	It("should export phase spans to the OTLP endpoint", func() {
+		ctx := context.Background()
+		cfg := &config.Config{TestCase: "01-p2p", Tracing: config.Tracing{Endpoint: receiver.Endpoint(), SampleRatio: 1}}
+		shutdown, err := tracing.Setup(ctx, cfg)
+		Expect(err).ToNot(HaveOccurred())
+
+		info := &iperf3.Info{TestCase: "01-p2p", CNIName: "cilium", Labels: map[string]string{"mtu": "9000"}}
+		ctx, root := tracing.Start(ctx, "target", trace.WithAttributes(info.Attributes()...))
+		attempts := 0
+		operation := tracing.Attempt(ctx, "store.attempt", func() error {
+			attempts++
+			if attempts == 1 {
+				return errors.New("connection refused")
+			}
+			return nil
+		})
+		Expect(operation()).ToNot(Succeed())
+		Expect(operation()).To(Succeed())
+		tracing.End(root, nil)
+		Expect(shutdown(context.Background())).To(Succeed())
+
+		spans := receiver.Spans()
+		Expect(spans).To(HaveLen(3))
+		byName := map[string][]*tracev1.Span{}
+		for _, span := range spans {
+			byName[span.GetName()] = append(byName[span.GetName()], span)
+		}
+		Expect(byName["target"]).To(HaveLen(1))
+		target := byName["target"][0]
+		Expect(attributes(target)).To(Equal(map[string]string{
+			"benchmark.test_case": "01-p2p", "cni.name": "cilium", "benchmark.label.mtu": "9000",
+		}))
+		Expect(byName["store.attempt"]).To(HaveLen(2))
+		for _, attempt := range byName["store.attempt"] {
+			Expect(attempt.GetParentSpanId()).To(Equal(target.GetSpanId()))
+		}
+		Expect(byName["store.attempt"]).To(ContainElement(
+			HaveField("Status.Code", tracev1.Status_STATUS_CODE_ERROR),
+		))
+	})
+
+	It("should do nothing without an endpoint", func() {
+		shutdown, err := tracing.Setup(context.Background(), &config.Config{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(shutdown(context.Background())).To(Succeed())
+	})
+})
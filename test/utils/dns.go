@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"errors"
+	"net"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// DNSServFail makes the DNS stand-in answer with SERVFAIL
+	DNSServFail = "SERVFAIL"
+	// DNSDrop makes the DNS stand-in never answer
+	DNSDrop = "DROP"
+)
+
+// DNSServer is an in-process DNS stand-in answering A queries over UDP.
+// Zone values are IPv4 addresses, DNSServFail or DNSDrop; unknown names
+// get NXDOMAIN.
+type DNSServer struct {
+	conn net.PacketConn
+	zone map[string]string
+}
+
+// StartDNSServer listens on a random localhost port and serves the zone
+func StartDNSServer(zone map[string]string) (*DNSServer, error) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	server := &DNSServer{conn: conn, zone: map[string]string{}}
+	for name, value := range zone {
+		server.zone[strings.TrimSuffix(name, ".")+"."] = value
+	}
+	go server.serve()
+	return server, nil
+}
+
+// Addr returns host:port the stand-in listens on
+func (s *DNSServer) Addr() string {
+	return s.conn.LocalAddr().String()
+}
+
+// Close stops the stand-in
+func (s *DNSServer) Close() error {
+	return s.conn.Close()
+}
+
+func (s *DNSServer) serve() {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			continue
+		}
+		if response, ok := s.answer(buf[:n]); ok {
+			_, _ = s.conn.WriteTo(response, addr)
+		}
+	}
+}
+
+func (s *DNSServer) answer(query []byte) ([]byte, bool) {
+	var request dnsmessage.Message
+	if err := request.Unpack(query); err != nil || len(request.Questions) == 0 {
+		return nil, false
+	}
+	question := request.Questions[0]
+	response := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:                 request.ID,
+			Response:           true,
+			RecursionDesired:   request.RecursionDesired,
+			RecursionAvailable: true,
+		},
+		Questions: request.Questions,
+	}
+	value, ok := s.zone[question.Name.String()]
+	switch {
+	case !ok:
+		response.RCode = dnsmessage.RCodeNameError
+	case value == DNSDrop:
+		return nil, false
+	case value == DNSServFail:
+		response.RCode = dnsmessage.RCodeServerFailure
+	default:
+		var a dnsmessage.AResource
+		copy(a.A[:], net.ParseIP(value).To4())
+		response.Answers = []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 30},
+			Body:   &a,
+		}}
+	}
+	packed, err := response.Pack()
+	if err != nil {
+		return nil, false
+	}
+	return packed, true
+}
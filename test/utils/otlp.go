@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracev1 "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// OTLPReceiver is an in-process OTLP/HTTP stand-in collecting exported spans
+type OTLPReceiver struct {
+	server *httptest.Server
+	mu     sync.Mutex
+	spans  []*tracev1.Span
+}
+
+// StartOTLPReceiver listens on a random localhost port for /v1/traces
+func StartOTLPReceiver() *OTLPReceiver {
+	receiver := &OTLPReceiver{}
+	receiver.server = httptest.NewServer(http.HandlerFunc(receiver.export))
+	return receiver
+}
+
+// Endpoint returns the URL to configure the exporter with
+func (r *OTLPReceiver) Endpoint() string {
+	return r.server.URL
+}
+
+// Spans returns the spans received so far
+func (r *OTLPReceiver) Spans() []*tracev1.Span {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*tracev1.Span(nil), r.spans...)
+}
+
+// Close stops the stand-in
+func (r *OTLPReceiver) Close() {
+	r.server.Close()
+}
+
+func (r *OTLPReceiver) export(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/v1/traces" || req.Method != http.MethodPost {
+		http.NotFound(w, req)
+		return
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	request := &collectortrace.ExportTraceServiceRequest{}
+	if err = proto.Unmarshal(body, request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.mu.Lock()
+	for _, resourceSpans := range request.GetResourceSpans() {
+		for _, scopeSpans := range resourceSpans.GetScopeSpans() {
+			r.spans = append(r.spans, scopeSpans.GetSpans()...)
+		}
+	}
+	r.mu.Unlock()
+
+	response, err := proto.Marshal(&collectortrace.ExportTraceServiceResponse{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	_, _ = w.Write(response)
+}